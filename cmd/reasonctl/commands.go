@@ -0,0 +1,267 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luids-io/core/reason"
+)
+
+func parseCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print as json, one object per line")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	reasons, err := readReasons(fs.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "reasonctl: reading input: %v\n", err)
+		return 1
+	}
+	status, printed := 0, false
+	for _, s := range reasons {
+		r, err := reason.Parse(s)
+		if err != nil {
+			fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+			status = 1
+			continue
+		}
+		if *asJSON {
+			data, err := json.Marshal(r)
+			if err != nil {
+				fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+				status = 1
+				continue
+			}
+			fmt.Fprintf(stdout, "%s\n", data)
+			continue
+		}
+		if printed {
+			fmt.Fprintln(stdout)
+		}
+		printReason(stdout, r)
+		printed = true
+	}
+	return status
+}
+
+func printReason(w io.Writer, r reason.Reason) {
+	fmt.Fprintf(w, "score: %v\n", r.Score)
+	for _, sc := range r.Scores {
+		if sc.Source != "" {
+			fmt.Fprintf(w, "  %s: %v\n", sc.Source, sc.Value)
+		}
+	}
+	fields := r.Policy.Fields()
+	sort.Strings(fields)
+	fmt.Fprintln(w, "policy:")
+	for _, field := range fields {
+		value, _ := r.Policy.Get(field)
+		fmt.Fprintf(w, "  %s: %s\n", field, strconv.Quote(value))
+	}
+	if len(r.Tags) > 0 {
+		fmt.Fprintln(w, "tags:")
+		for _, t := range r.Tags {
+			fmt.Fprintf(w, "  %s: %s\n", t.Name, strconv.Quote(t.Value))
+		}
+	}
+	fmt.Fprintf(w, "text: %s\n", r.Text)
+}
+
+func editCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var sets, unsets, addScores listFlag
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Var(&sets, "set", "set policy `field=value`, can be repeated")
+	fs.Var(&unsets, "unset", "remove policy `field`, can be repeated")
+	score := fs.String("score", "", "replace all scores by `n`, 0 removes scores")
+	fs.Var(&addScores, "add-score", "add or replace score `n@source`, can be repeated")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	reasons, err := readReasons(fs.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "reasonctl: reading input: %v\n", err)
+		return 1
+	}
+	status := 0
+	for _, s := range reasons {
+		s, err = edit(s, *score, addScores, sets, unsets)
+		if err != nil {
+			fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+			status = 1
+			continue
+		}
+		fmt.Fprintln(stdout, s)
+	}
+	return status
+}
+
+func edit(s, score string, addScores, sets, unsets []string) (string, error) {
+	if score != "" {
+		n, err := strconv.Atoi(score)
+		if err != nil {
+			return s, fmt.Errorf("invalid score '%s'", score)
+		}
+		s = reason.WithScore(n, s)
+	}
+	for _, add := range addScores {
+		idx := strings.IndexByte(add, '@')
+		if idx < 0 {
+			return s, fmt.Errorf("invalid score '%s': source is required", add)
+		}
+		n, err := strconv.Atoi(add[:idx])
+		if err != nil {
+			return s, fmt.Errorf("invalid score '%s'", add)
+		}
+		s, err = reason.AddScore(n, add[idx+1:], s)
+		if err != nil {
+			return s, err
+		}
+	}
+	if len(sets) == 0 && len(unsets) == 0 {
+		return s, nil
+	}
+	policy, rest, err := reason.ExtractPolicy(s)
+	if err != nil {
+		return s, err
+	}
+	for _, field := range unsets {
+		policy.Delete(field)
+	}
+	for _, set := range sets {
+		idx := strings.IndexByte(set, '=')
+		if idx < 0 {
+			return s, fmt.Errorf("invalid field '%s': value is required", set)
+		}
+		err := policy.Set(set[:idx], set[idx+1:])
+		if err != nil {
+			return s, err
+		}
+	}
+	return reason.WithPolicy(policy, rest), nil
+}
+
+func mergeCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var c reason.Combiner
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	asJSON := fs.Bool("json", false, "print as json")
+	agg := fs.String("agg", "sum", "score aggregation `mode`: sum, max, min or avg")
+	labels := fs.String("labels", "", "comma separated `sources` of the reasons, in order")
+	fs.StringVar(&c.Separator, "sep", "; ", "`separator` of text fragments")
+	verbose := fs.Bool("v", false, "print policy conflicts")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	c.Aggregation.Mode = reason.ScoreMode(*agg)
+	reasons, err := readReasons(fs.Args(), stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "reasonctl: reading input: %v\n", err)
+		return 1
+	}
+	var names []string
+	if *labels != "" {
+		names = strings.Split(*labels, ",")
+	}
+	if len(names) > len(reasons) {
+		fmt.Fprintf(stderr, "reasonctl: there are more labels than reasons\n")
+		return 2
+	}
+	sources := make([]reason.Source, 0, len(reasons))
+	for i, s := range reasons {
+		src := reason.Source{Reason: s}
+		if i < len(names) {
+			src.Name = names[i]
+		}
+		sources = append(sources, src)
+	}
+	r, conflicts, err := c.Combine(sources...)
+	if err != nil {
+		fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+		return 1
+	}
+	if *verbose {
+		for _, conflict := range conflicts {
+			fmt.Fprintf(stderr, "conflict: %v\n", conflict)
+		}
+	}
+	if *asJSON {
+		data, err := json.Marshal(r)
+		if err != nil {
+			fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "%s\n", data)
+		return 0
+	}
+	s, err := r.Encode()
+	if err != nil {
+		fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(stdout, s)
+	return 0
+}
+
+func validateCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fname := fs.String("f", "-", "`file` with reason strings, - is standard input")
+	quiet := fs.Bool("q", false, "don't print the summary")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "reasonctl: validate doesn't accept arguments\n")
+		return 2
+	}
+	in := stdin
+	if *fname != "-" {
+		f, err := os.Open(*fname)
+		if err != nil {
+			fmt.Fprintf(stderr, "reasonctl: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	lines, invalid := 0, 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		_, problems := reason.ParseDiag(line)
+		if len(problems) > 0 {
+			invalid++
+		}
+		for _, p := range problems {
+			fmt.Fprintf(stdout, "%s:%v: %v\n", *fname, lines, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(stderr, "reasonctl: reading %s: %v\n", *fname, err)
+		return 1
+	}
+	if !*quiet {
+		fmt.Fprintf(stdout, "%v lines, %v invalid\n", lines, invalid)
+	}
+	if invalid > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+// Command reasonctl inspects and edits reason strings.
+//
+// Reason strings are read from the arguments or, if there are no arguments,
+// from the standard input, one per line. Usage:
+//
+//	reasonctl parse [-json] [reason...]
+//	reasonctl edit [-set field=value] [-unset field] [-score n] [-add-score n@source] [reason...]
+//	reasonctl merge [-json] [-agg mode] [-labels l1,l2] [-sep sep] [reason...]
+//	reasonctl validate [-f file] [-q]
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `usage: reasonctl <command> [flags] [reason...]
+
+commands:
+  parse     print the score, policy and clean text of reason strings
+  edit      set or remove policy fields and scores of reason strings
+  merge     merge reason strings into one
+  validate  validate reason strings from a file, one per line
+
+Reason strings are read from the arguments or from standard input.
+Run 'reasonctl <command> -h' for the flags of a command.
+`
+
+// command sets definition for the commands.
+type command func(args []string, stdin io.Reader, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"parse":    parseCmd,
+	"edit":     editCmd,
+	"merge":    mergeCmd,
+	"validate": validateCmd,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command and returns the exit status: 0 if ok, 1 if
+// there were errors and 2 if usage is wrong.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		if args[0] != "help" && args[0] != "-h" && args[0] != "-help" {
+			fmt.Fprintf(stderr, "reasonctl: unknown command '%s'\n", args[0])
+		}
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	return cmd(args[1:], stdin, stdout, stderr)
+}
+
+// readReasons returns the reason strings from args or, if empty, the non
+// empty lines of r.
+func readReasons(args []string, r io.Reader) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	var reasons []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line != "" {
+			reasons = append(reasons, line)
+		}
+	}
+	return reasons, scanner.Err()
+}
+
+// listFlag is a flag that can be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var tests = []struct {
+		args       []string
+		stdin      string
+		wantStatus int
+		wantOut    string
+	}{
+		{[]string{}, "", 2, ""},
+		{[]string{"unknown"}, "", 2, ""},
+		{[]string{"parse", "[score]10@l1[/score][policy]dns=nxdomain[/policy]razón"}, "", 0,
+			"score: 10\n  l1: 10\npolicy:\n  dns: \"nxdomain\"\ntext: razón\n"},
+		{[]string{"parse", "-json"}, "[score]10[/score]razón\n\n[policy]ttl=60[/policy]otra\n", 0,
+			`{"text":"razón","score":10,"policy":{}}` + "\n" + `{"text":"otra","score":0,"policy":{"ttl":"60"}}` + "\n"},
+		{[]string{"parse", "[score]bad[/score]razón"}, "", 1, ""},
+		{[]string{"parse"}, "[score]bad[/score]razón\nrazón\n", 1, "score: 0\npolicy:\ntext: razón\n"},
+		{[]string{"edit", "-set", "dns=checkip", "-set", "msg=a b", "-unset", "ttl", "[policy]dns=nxdomain,ttl=60[/policy]razón"}, "", 0,
+			"[policy]dns=checkip,msg=\"a b\"[/policy]razón\n"},
+		{[]string{"edit", "-score", "0", "-add-score", "20@l2"}, "[score]10[/score]razón\n", 0,
+			"[score]20@l2[/score]razón\n"},
+		{[]string{"edit", "-add-score", "20"}, "razón\n", 1, ""},
+		{[]string{"merge", "-labels", "l1,l2", "[score]10[/score]malware", "[score]5[/score][policy]dns=nxdomain[/policy]malware; phishing"}, "", 0,
+			"[score]10@l1[/score][score]5@l2[/score][policy]dns=nxdomain[/policy]malware; phishing\n"},
+		{[]string{"merge", "-agg", "max"}, "[score]10[/score]a\n[score]30[/score]b\n", 0,
+			"[score]30[/score]a; b\n"},
+		{[]string{"merge", "-agg", "bad", "[score]1[/score]a"}, "", 1, ""},
+		{[]string{"validate", "-q"}, "[score]10[/score]razón\n[policy]dns=nxdomain[/policy]ok\n", 0, ""},
+		{[]string{"validate"}, "razón\n[score]bad[/score]razón\n", 1,
+			"-:2: offset 7: score: invalid score 'bad'\n2 lines, 1 invalid\n"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		status := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+		if status != test.wantStatus {
+			t.Errorf("run(%v) want status=%v got=%v (stderr: %s)", test.args, test.wantStatus, status, stderr.String())
+		}
+		if stdout.String() != test.wantOut {
+			t.Errorf("run(%v) want=%q got=%q", test.args, test.wantOut, stdout.String())
+		}
+	}
+}
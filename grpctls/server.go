@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"strings"
 
 	"google.golang.org/grpc/credentials"
+
+	"github.com/luids-io/core/serverd/activation"
 )
 
 // ServerCfg defines configuration for a server.
@@ -106,13 +109,23 @@ func Creds(cfg ServerCfg) (credentials.TransportCredentials, error) {
 	return creds, nil
 }
 
-// Listener returns a valid listener server from an URI.
+// Listener returns a valid listener server from an URI. If the process
+// inherited a listener for the address (socket activation), it's returned.
+// URIs with the format 'fd://name' return the inherited listener with the
+// name.
 func Listener(uri string) (net.Listener, error) {
+	if strings.HasPrefix(uri, "fd://") {
+		lis, err := activation.ListenNamed(uri[5:])
+		if err != nil {
+			return nil, fmt.Errorf("grpctls: cannot get socket '%v': %v", uri, err)
+		}
+		return lis, nil
+	}
 	proto, addr, err := ParseURI(uri)
 	if err != nil {
 		return nil, fmt.Errorf("grpctls: cannot parse address '%v': %v", uri, err)
 	}
-	lis, err := net.Listen(proto, addr)
+	lis, err := activation.Listen(proto, addr)
 	if err != nil {
 		return nil, fmt.Errorf("grpctls: cannot listen socket '%v': %v", uri, err)
 	}
@@ -34,6 +34,7 @@ type options struct {
 	ipfilter ipfilter.Filter
 	metrics  bool
 	profile  bool
+	handlers map[string]http.Handler
 }
 
 var defaultOptions = options{logger: yalogi.LogNull}
@@ -66,6 +67,17 @@ func Profile(b bool) Option {
 	}
 }
 
+// Handle option mounts an additional handler in the path, for example the
+// status handler of a serverd manager.
+func Handle(path string, h http.Handler) Option {
+	return func(o *options) {
+		if o.handlers == nil {
+			o.handlers = make(map[string]http.Handler)
+		}
+		o.handlers[path] = h
+	}
+}
+
 // Server is an http server that provides health services.
 // It must be constructed using New.
 type Server struct {
@@ -126,6 +138,9 @@ func (s *Server) handler() http.Handler {
 		attachProfiler(router)
 	}
 	router.HandleFunc("/health", s.doHealth).Methods("GET")
+	for path, h := range s.opts.handlers {
+		router.Handle(path, h)
+	}
 
 	if !s.opts.ipfilter.Empty() {
 		filtered := s.opts.ipfilter
@@ -0,0 +1,159 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source is a reason string labelled with the name of the source that
+// returned it (a list, a sensor...). Name is optional.
+type Source struct {
+	Name   string
+	Reason string
+}
+
+// Combiner combines reason strings from several sources into one.
+type Combiner struct {
+	// Aggregation of the scores, zero value sums all scores
+	Aggregation ScoreAggregation
+	// Merger used for the policies
+	Merger Merger
+	// Separator between text fragments, if empty "; " is used
+	Separator string
+}
+
+// Combine combines the reason strings of the sources in order:
+//   - scores without source get the name of the source and scores with the
+//     same source in a reason string are summed, if two sources return
+//     scores with the same source the last one is kept, then they are
+//     aggregated
+//   - policies are merged using Merger, conflicts are returned
+//   - custom tags are merged using their codecs, the last wins if there is
+//     no merge function
+//   - other tags and text fragments are de-duplicated, signatures are
+//     removed because they aren't valid for the result
+//
+// Sources in conflicts are the indexes of the sources.
+func (c Combiner) Combine(sources ...Source) (Reason, []Conflict, error) {
+	r := Reason{Policy: NewPolicy()}
+	policies := make([]Policy, 0, len(sources))
+	scores := make(map[string]int)
+	tags := make(map[Tag]bool)
+	texts := make(map[string]bool)
+	var fragments []string
+	for i, src := range sources {
+		if src.Name != "" && !isSource(src.Name) {
+			return r, nil, fmt.Errorf("invalid source name '%s'", src.Name)
+		}
+		pr, err := Parse(src.Reason)
+		if err != nil {
+			return r, nil, fmt.Errorf("source %v: %v", i, err)
+		}
+		for _, sc := range sourceScores(src.Name, pr.Scores) {
+			if sc.Source == "" {
+				r.Scores = append(r.Scores, sc)
+				continue
+			}
+			if j, ok := scores[sc.Source]; ok {
+				r.Scores[j] = sc
+				continue
+			}
+			scores[sc.Source] = len(r.Scores)
+			r.Scores = append(r.Scores, sc)
+		}
+		policies = append(policies, pr.Policy)
+		for name, v := range pr.Custom {
+			v, err = c.mergeTag(name, r.Custom[name], v)
+			if err != nil {
+				return r, nil, fmt.Errorf("source %v: %v", i, err)
+			}
+			if r.Custom == nil {
+				r.Custom = make(map[string]interface{})
+			}
+			r.Custom[name] = v
+		}
+		for _, t := range pr.Tags {
+			if t.Name != sigTag && !tags[t] {
+				tags[t] = true
+				r.Tags = append(r.Tags, t)
+			}
+		}
+		for _, f := range strings.Split(pr.Text, c.separator()) {
+			f = strings.TrimSpace(f)
+			if f != "" && !texts[f] {
+				texts[f] = true
+				fragments = append(fragments, f)
+			}
+		}
+	}
+	var err error
+	r.Score, err = c.Aggregation.Aggregate(r.Scores)
+	if err != nil {
+		return r, nil, err
+	}
+	var conflicts []Conflict
+	r.Policy, conflicts, err = c.Merger.Merge(policies...)
+	if err != nil {
+		return r, conflicts, err
+	}
+	r.Text = strings.Join(fragments, c.separator())
+	return r, conflicts, nil
+}
+
+// sourceScores returns the scores of a source, scores without source get
+// the name of the source and scores with the same source are summed.
+func sourceScores(name string, scores []Score) []Score {
+	summed := make([]Score, 0, len(scores))
+	index := make(map[string]int)
+	for _, sc := range scores {
+		if sc.Source == "" {
+			sc.Source = name
+		}
+		if sc.Source == "" {
+			summed = append(summed, sc)
+			continue
+		}
+		if j, ok := index[sc.Source]; ok {
+			summed[j].Value = summed[j].Value + sc.Value
+			continue
+		}
+		index[sc.Source] = len(summed)
+		summed = append(summed, sc)
+	}
+	return summed
+}
+
+func (c Combiner) separator() string {
+	if c.Separator == "" {
+		return "; "
+	}
+	return c.Separator
+}
+
+func (c Combiner) mergeTag(name string, prev, v interface{}) (interface{}, error) {
+	codec, _ := getCodec(name)
+	if prev == nil || codec.Merge == nil {
+		return v, nil
+	}
+	merged, err := codec.Merge(prev, v)
+	if err != nil {
+		return nil, fmt.Errorf("merging %s: %v", name, err)
+	}
+	return merged, nil
+}
+
+// Combine combines the reason strings using the default rules and returns
+// the canonical encoding of the result.
+func Combine(reasons ...string) (string, error) {
+	sources := make([]Source, 0, len(reasons))
+	for _, s := range reasons {
+		sources = append(sources, Source{Reason: s})
+	}
+	r, _, err := Combiner{}.Combine(sources...)
+	if err != nil {
+		return "", err
+	}
+	return r.Encode()
+}
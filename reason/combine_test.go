@@ -0,0 +1,108 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestCombine(t *testing.T) {
+	var tests = []struct {
+		in      []string
+		wantErr bool
+		want    string
+	}{
+		{[]string{}, false, ""},
+		{[]string{"razón"}, false, "razón"},
+		{[]string{"[score]10[/score]razón", "[score]5[/score]razón"}, false, "[score]15[/score]razón"},
+		{[]string{"[score]10[/score]malware", "[policy]dns=nxdomain[/policy]phishing; malware"},
+			false, "[score]10[/score][policy]dns=nxdomain[/policy]malware; phishing"},
+		{[]string{"[policy]dns=nxdomain,ttl=60[/policy]a", "[policy]dns=checkip[/policy]b"},
+			false, "[policy]dns=checkip,ttl=60[/policy]a; b"},
+		{[]string{"[lists]l1[/lists][ttl]60[/ttl]a", "[lists]l2[/lists][ttl]60[/ttl]a"},
+			false, "[lists]l1,l2[/lists][ttl]60[/ttl]a"},
+		{[]string{"razón", "[score]bad[/score]razón"}, true, ""},
+	}
+	for _, test := range tests {
+		got, err := reason.Combine(test.in...)
+		switch {
+		case test.wantErr && err == nil:
+			t.Errorf("Combine(%v) expected err", test.in)
+		case !test.wantErr && err != nil:
+			t.Errorf("Combine(%v) unexpected err: %v", test.in, err)
+		case got != test.want:
+			t.Errorf("Combine(%v) want=%v got=%v", test.in, test.want, got)
+		}
+	}
+}
+
+func TestCombinerCombine(t *testing.T) {
+	c := reason.Combiner{
+		Aggregation: reason.ScoreAggregation{Mode: reason.ScoreMax},
+		Merger: reason.Merger{
+			Fields: map[string]reason.MergeFn{
+				"action": reason.Precedence("allow", "log", "block"),
+			},
+		},
+		Separator: " | ",
+	}
+	sources := []reason.Source{
+		{Name: "list1", Reason: "[score]10[/score][policy]action=log[/policy]malware"},
+		{Name: "list2", Reason: "[score]30[/score][policy]action=block[/policy]malware | botnet"},
+		{Name: "list3", Reason: "[score]20@other[/score][policy]action=allow[/policy]"},
+	}
+	r, conflicts, err := c.Combine(sources...)
+	if err != nil {
+		t.Fatalf("Combine unexpected err: %v", err)
+	}
+	if r.Score != 30 {
+		t.Errorf("Combine score want=30 got=%v", r.Score)
+	}
+	if want := []reason.Score{{Value: 10, Source: "list1"}, {Value: 30, Source: "list2"}, {Value: 20, Source: "other"}}; len(r.Scores) != len(want) {
+		t.Errorf("Combine scores want=%v got=%v", want, r.Scores)
+	} else {
+		for i := range want {
+			if r.Scores[i] != want[i] {
+				t.Errorf("Combine scores want=%v got=%v", want, r.Scores)
+			}
+		}
+	}
+	if want := "[score]30[/score][policy]action=block[/policy]malware | botnet"; r.String() != want {
+		t.Errorf("Combine want=%v got=%v", want, r.String())
+	}
+	if len(conflicts) != 2 || conflicts[1].Overridden != 2 {
+		t.Errorf("Combine unexpected conflicts: %v", conflicts)
+	}
+	// same source replaces score
+	c = reason.Combiner{}
+	r, _, err = c.Combine(
+		reason.Source{Name: "list1", Reason: "[score]10[/score]a"},
+		reason.Source{Name: "list1", Reason: "[score]20[/score]a"},
+		reason.Source{Name: "list2", Reason: "[score]5[/score]a"})
+	if err != nil {
+		t.Fatalf("Combine unexpected err: %v", err)
+	}
+	if want := "[score]20@list1[/score][score]5@list2[/score]a"; r.String() != want {
+		t.Errorf("Combine want=%v got=%v", want, r.String())
+	}
+	// scores of a source are summed
+	r, _, err = c.Combine(
+		reason.Source{Name: "list1", Reason: "[score]10[/score][score]5[/score][score]1@other[/score]a"},
+		reason.Source{Name: "list2", Reason: "[score]20[/score]a"},
+		reason.Source{Reason: "[score]2[/score][score]3[/score]a"})
+	if err != nil {
+		t.Fatalf("Combine unexpected err: %v", err)
+	}
+	if score, _, _ := reason.ExtractScore(r.String()); r.Score != 41 || score != 41 {
+		t.Errorf("Combine score want=41 got=%v", r.Score)
+	}
+	if want := "[score]15@list1[/score][score]1@other[/score][score]20@list2[/score][score]2[/score][score]3[/score]a"; r.String() != want {
+		t.Errorf("Combine want=%v got=%v", want, r.String())
+	}
+	_, _, err = c.Combine(reason.Source{Name: "list 1", Reason: "a"})
+	if err == nil {
+		t.Error("Combine expected err with invalid source name")
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem stores a problem found by ParseDiag.
+type Problem struct {
+	// Offset in bytes of the problem in the reason string
+	Offset int
+	// Tag where the problem was found
+	Tag string
+	// Msg describes the problem
+	Msg string
+}
+
+// String implements fmt.Stringer.
+func (p Problem) String() string {
+	return fmt.Sprintf("offset %v: %s: %s", p.Offset, p.Tag, p.Msg)
+}
+
+// ParseDiag decodes a reason string like Parse, but in a lenient mode: it
+// doesn't stop on errors and returns all the problems found (unterminated
+// tags, unexpected close tags, nested tags, invalid scores, invalid policy
+// fields and invalid custom tags) with their offsets, extracting everything
+// that is valid.
+func ParseDiag(s string) (Reason, []Problem) {
+	var problems []Problem
+	r := Reason{Policy: NewPolicy()}
+	names := knownTags()
+	blocks, rest := scanTags(s, names...)
+	problems = append(problems, checkMarkers(s, blocks)...)
+	// tags joined removing other tags, as scanKnown does
+	pos := keptPositions(nil, s, blocks)
+	for more := blocks; len(more) > 0; {
+		var next string
+		more, next = scanTags(rest, names...)
+		for _, b := range more {
+			problems = append(problems, Problem{Offset: pos[b.offset], Tag: b.name,
+				Msg: "tag joined removing other tags"})
+			b.offset = pos[b.offset]
+			blocks = append(blocks, b)
+		}
+		pos = keptPositions(pos, rest, more)
+		rest = next
+	}
+	r.Text = rest
+	for _, b := range blocks {
+		voffset := b.offset + len(b.name) + 2 // len("[]") == 2
+		switch b.name {
+		case scoreTag:
+			sc, err := scoreFromValue(b.value)
+			if err != nil {
+				problems = append(problems, Problem{Offset: voffset, Tag: b.name, Msg: err.Error()})
+				continue
+			}
+			r.Score = r.Score + sc.Value
+			r.Scores = append(r.Scores, sc)
+		case policyTag:
+			np := NewPolicy()
+			for _, p := range np.fromValueLenient(b.value) {
+				p.Offset = p.Offset + voffset
+				problems = append(problems, p)
+			}
+			r.Policy.Merge(np)
+		default:
+			codec, ok := getCodec(b.name)
+			if !ok {
+				r.Tags = append(r.Tags, Tag{Name: b.name, Value: b.value})
+				continue
+			}
+			if r.Custom == nil {
+				r.Custom = make(map[string]interface{})
+			}
+			v, err := decodeTag(codec, b, r.Custom[b.name])
+			if err != nil {
+				problems = append(problems, Problem{Offset: voffset, Tag: b.name, Msg: err.Error()})
+				continue
+			}
+			r.Custom[b.name] = v
+		}
+	}
+	return r, problems
+}
+
+// keptPositions returns the positions in the original string of the bytes
+// of s not removed with the blocks. pos are the positions of the bytes of s,
+// if nil s is the original string.
+func keptPositions(pos []int, s string, blocks []tagBlock) []int {
+	if pos == nil {
+		pos = make([]int, len(s))
+		for i := range pos {
+			pos[i] = i
+		}
+	}
+	kept := make([]int, 0, len(pos))
+	last := 0
+	for _, b := range blocks {
+		kept = append(kept, pos[last:b.offset]...)
+		last = b.end
+	}
+	return append(kept, pos[last:]...)
+}
+
+// checkMarkers returns problems with the known tags outside and inside the
+// blocks found.
+func checkMarkers(s string, blocks []tagBlock) []Problem {
+	var problems []Problem
+	names := knownTags()
+	next := 0 // next block
+	for i := 0; i < len(s); {
+		idx := strings.IndexByte(s[i:], '[')
+		if idx < 0 {
+			break
+		}
+		pos := i + idx
+		for next < len(blocks) && blocks[next].end <= pos {
+			next++
+		}
+		name, isClose, end := markerAt(s, pos)
+		k := -1
+		if name != "" {
+			k = tagIndex(name, names)
+		}
+		if k < 0 {
+			i = pos + 1
+			continue
+		}
+		name = names[k]
+		inside := next < len(blocks) && blocks[next].offset <= pos
+		switch {
+		case inside:
+			b := blocks[next]
+			if pos == b.offset || end == b.end {
+				break // markers of the block
+			}
+			problems = append(problems, Problem{Offset: pos, Tag: b.name,
+				Msg: fmt.Sprintf("tag '%s' nested", name)})
+		case isClose:
+			problems = append(problems, Problem{Offset: pos, Tag: name, Msg: "unexpected close tag"})
+		default:
+			problems = append(problems, Problem{Offset: pos, Tag: name, Msg: "unterminated tag"})
+		}
+		i = end
+	}
+	return problems
+}
+
+// fromValueLenient loads the valid values of a policy from the content of a
+// policy tag, returning the problems found with their offsets in s.
+func (p *Policy) fromValueLenient(s string) []Problem {
+	var problems []Problem
+	m := make(map[string]string, 0)
+	for i := 0; i >= 0; {
+		field, raw, next, err := nextField(s, i)
+		if err != nil {
+			problems = append(problems, Problem{Offset: i, Tag: policyTag, Msg: err.Error()})
+			// skip to the next separator
+			next = -1
+			if j := strings.IndexByte(s[i:], ','); j >= 0 {
+				next = i + j + 1
+			}
+		} else {
+			m[field] = decodeValue(raw)
+		}
+		i = next
+	}
+	p.m = m
+	return problems
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestParseDiag(t *testing.T) {
+	var tests = []struct {
+		in           string
+		wantText     string
+		wantScore    int
+		wantPolicy   string
+		wantProblems []reason.Problem
+	}{
+		{"[score]10[/score][policy]dns=nxdomain[/policy]razón", "razón", 10, "[policy]dns=nxdomain[/policy]", nil},
+		{"[/score][score]10[/score]razón", "[/score]razón", 10, "",
+			[]reason.Problem{{Offset: 0, Tag: "score", Msg: "unexpected close tag"}}},
+		{"razón[score]10", "razón[score]10", 0, "",
+			[]reason.Problem{{Offset: 6, Tag: "score", Msg: "unterminated tag"}}},
+		{"[score][score]1[/score]razón", "razón", 0, "",
+			[]reason.Problem{
+				{Offset: 7, Tag: "score", Msg: "tag 'score' nested"},
+				{Offset: 7, Tag: "score", Msg: "invalid score '[score]1'"},
+			}},
+		{"[policy]dns=nxdomain[score]1[/score][/policy]razón", "razón", 0, "",
+			[]reason.Problem{
+				{Offset: 20, Tag: "policy", Msg: "tag 'score' nested"},
+				{Offset: 28, Tag: "policy", Msg: "tag 'score' nested"},
+				{Offset: 8, Tag: "policy", Msg: "invalid value 'nxdomain[score]1[/score]'"},
+			}},
+		{"[score]x[/score][score]5[/score][policy]dns=nxdomain,1bad=x,log=\"a b\",ev=b c[/policy]razón", "razón", 5,
+			"[policy]dns=nxdomain,log=\"a b\"[/policy]",
+			[]reason.Problem{
+				{Offset: 7, Tag: "score", Msg: "invalid score 'x'"},
+				{Offset: 53, Tag: "policy", Msg: "invalid field '1bad'"},
+				{Offset: 70, Tag: "policy", Msg: "invalid value 'b c'"},
+			}},
+		{"ra[sco[policy]x=1[/policy]re]1[/score]zón", "razón", 1, "[policy]x=1[/policy]",
+			[]reason.Problem{
+				{Offset: 30, Tag: "score", Msg: "unexpected close tag"},
+				{Offset: 2, Tag: "score", Msg: "tag joined removing other tags"},
+			}},
+		{"[confidence]high[/confidence][confidence]80[/confidence]razón", "razón", 0, "",
+			[]reason.Problem{{Offset: 12, Tag: "confidence", Msg: "invalid confidence '[confidence]high[/confidence]': strconv.Atoi: parsing \"high\": invalid syntax"}}},
+	}
+	for _, test := range tests {
+		r, problems := reason.ParseDiag(test.in)
+		if r.Text != test.wantText || r.Score != test.wantScore || r.Policy.String() != test.wantPolicy {
+			t.Errorf("ParseDiag(%s) = %v, %v, %v", test.in, r.Text, r.Score, r.Policy.String())
+		}
+		if len(problems) != len(test.wantProblems) {
+			t.Errorf("ParseDiag(%s) problems want=%v got=%v", test.in, test.wantProblems, problems)
+			continue
+		}
+		for i := range problems {
+			if problems[i] != test.wantProblems[i] {
+				t.Errorf("ParseDiag(%s) problem want=%v got=%v", test.in, test.wantProblems[i], problems[i])
+			}
+		}
+	}
+}
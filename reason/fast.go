@@ -0,0 +1,267 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FastParser is a reusable parser for hot paths. In a single pass over the
+// reason string it gets the same results as ExtractScore, ExtractPolicy and
+// Clean, without lower casing or copying the string and, once its internal
+// buffers have grown, without allocating memory.
+//
+// The zero value is ready to use. A FastParser is not safe for concurrent
+// use, but it can be pooled.
+type FastParser struct {
+	src       string
+	score     int
+	scoreErr  error
+	policies  []string
+	policyErr error
+	text      []span
+	removed   bool
+	joinable  bool
+	noClose   []bool
+}
+
+// span stores the limits of a fragment of text.
+type span struct {
+	start, end int
+}
+
+// Parse parses the reason string. Results are available until the next call.
+func (p *FastParser) Parse(s string) {
+	p.reset(s)
+	names := knownTags()
+	if cap(p.noClose) < len(names) {
+		p.noClose = make([]bool, len(names))
+	}
+	p.noClose = p.noClose[:len(names)]
+	for i := range p.noClose {
+		p.noClose[i] = false
+	}
+	scoreStart, policyStart := -1, -1 // start of values if inside tags
+	cleanClose, cleanEnd := -1, -1    // close tag if inside a tag
+	last := 0                         // start of the current text
+	openBracket := false              // text ends with an unclosed '['
+	for i := 0; i < len(s); {
+		idx := strings.IndexByte(s[i:], '[')
+		if idx < 0 {
+			break
+		}
+		pos := i + idx
+		name, isClose, end := markerAt(s, pos)
+		if name == "" {
+			i = pos + 1
+			continue
+		}
+		k := tagIndex(name, names)
+		if k < 0 {
+			i = pos + 1
+			continue
+		}
+		// score tags, as scanTags(s, scoreTag)
+		if names[k] == scoreTag {
+			if !isClose && scoreStart < 0 {
+				scoreStart = end
+			} else if isClose && scoreStart >= 0 {
+				p.addScore(s[scoreStart:pos])
+				scoreStart = -1
+			}
+		}
+		// policy tags, as scanTags(s, policyTag)
+		if names[k] == policyTag {
+			if !isClose && policyStart < 0 {
+				policyStart = end
+			} else if isClose && policyStart >= 0 {
+				p.addPolicy(s[policyStart:pos])
+				policyStart = -1
+			}
+		}
+		// all known tags, as scanTags(s, knownTags()...)
+		switch {
+		case cleanClose >= 0:
+			if pos == cleanClose {
+				last = cleanEnd
+				cleanClose, cleanEnd = -1, -1
+			}
+		case !isClose && !p.noClose[k]:
+			cleanClose, cleanEnd = closeTag(s, end, names[k])
+			if cleanClose < 0 {
+				// there are no more close tags for this name
+				p.noClose[k] = true
+				break
+			}
+			p.text = append(p.text, span{last, pos})
+			p.removed = true
+			// removing the tag could join the text into a new tag
+			seg := s[last:pos]
+			if lo, lc := strings.LastIndexByte(seg, '['), strings.LastIndexByte(seg, ']'); lo > lc {
+				openBracket = true
+			} else if lc >= 0 {
+				openBracket = false
+			}
+			p.joinable = p.joinable || openBracket
+		}
+		i = end
+	}
+	p.text = append(p.text, span{last, len(s)})
+}
+
+func (p *FastParser) reset(s string) {
+	p.src = s
+	p.score = 0
+	p.scoreErr = nil
+	p.policies = p.policies[:0]
+	p.policyErr = nil
+	p.text = p.text[:0]
+	p.removed = false
+	p.joinable = false
+}
+
+func (p *FastParser) addScore(value string) {
+	if p.scoreErr != nil {
+		return
+	}
+	sc, err := scoreFromValue(value)
+	if err != nil {
+		p.scoreErr = fmt.Errorf("invalid score '%s': %v", tagToString(scoreTag, value), err)
+		p.score = 0
+		return
+	}
+	p.score = p.score + sc.Value
+}
+
+func (p *FastParser) addPolicy(value string) {
+	if p.policyErr != nil {
+		return
+	}
+	for i := 0; i >= 0; {
+		var err error
+		_, _, i, err = nextField(value, i)
+		if err != nil {
+			p.policyErr = fmt.Errorf("invalid policy '%s': %v", tagToString(policyTag, value), err)
+			return
+		}
+	}
+	p.policies = append(p.policies, value)
+}
+
+// Score returns the same results as ExtractScore.
+func (p *FastParser) Score() (int, error) {
+	return p.score, p.scoreErr
+}
+
+// Policy returns the same results as ExtractPolicy.
+func (p *FastParser) Policy() (Policy, error) {
+	policy := NewPolicy()
+	if p.policyErr != nil {
+		return policy, p.policyErr
+	}
+	for _, value := range p.policies {
+		np := NewPolicy()
+		np.fromValue(value)
+		policy.Merge(np)
+	}
+	return policy, nil
+}
+
+// PolicyValue returns the value of a field of the policy without building
+// it. It only allocates memory if value is quoted.
+func (p *FastParser) PolicyValue(field string) (string, bool, error) {
+	if p.policyErr != nil {
+		return "", false, p.policyErr
+	}
+	for i := len(p.policies) - 1; i >= 0; i-- {
+		value := p.policies[i]
+		found, raw := false, ""
+		for j := 0; j >= 0; {
+			var f, r string
+			f, r, j, _ = nextField(value, j)
+			if f == field {
+				found, raw = true, r
+			}
+		}
+		if found {
+			return decodeValue(raw), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Text returns the same result as Clean. It only allocates memory if tags
+// were removed.
+func (p *FastParser) Text() string {
+	if !p.removed {
+		return p.src
+	}
+	var b strings.Builder
+	b.Grow(p.textLen())
+	for _, t := range p.text {
+		b.WriteString(p.src[t.start:t.end])
+	}
+	if p.joinable {
+		return rescan(b.String())
+	}
+	return b.String()
+}
+
+// AppendText appends the same result as Clean to dst.
+func (p *FastParser) AppendText(dst []byte) []byte {
+	n := len(dst)
+	for _, t := range p.text {
+		dst = append(dst, p.src[t.start:t.end]...)
+	}
+	if p.joinable {
+		if text := rescan(string(dst[n:])); len(text) != len(dst)-n {
+			dst = append(dst[:n], text...)
+		}
+	}
+	return dst
+}
+
+// rescan removes the tags joined removing other tags, as scanKnown does.
+func rescan(text string) string {
+	_, rest := scanKnown(text)
+	return rest
+}
+
+func (p *FastParser) textLen() int {
+	n := 0
+	for _, t := range p.text {
+		n = n + t.end - t.start
+	}
+	return n
+}
+
+// markerAt returns the name of the tag at position i, if it's a close tag
+// and the position after it. If there is not a valid tag, name is empty.
+func markerAt(s string, i int) (name string, isClose bool, end int) {
+	start := i + 1
+	if start < len(s) && s[start] == '/' {
+		isClose = true
+		start++
+	}
+	j := start
+	for ; j < len(s) && s[j] != ']'; j++ {
+		c := s[j]
+		if !isLetter(c) && (j == start || (!isDigit(c) && c != '_')) {
+			return "", false, i
+		}
+	}
+	if j == start || j >= len(s) {
+		return "", false, i
+	}
+	return s[start:j], isClose, j + 1
+}
+
+func tagIndex(name string, names []string) int {
+	for i, n := range names {
+		if len(n) == len(name) && strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+var fastSeeds = []string{
+	"razón",
+	"[score]10[/score][policy]dns=nxdomain,event=high[/policy]razón",
+	"r[score]1[/score]a[score]2[/score]zón[/score]",
+	"[/score]mal[/score]ra[score]2[/score]zón[/score]",
+	"r[score][score]1[/score]azón",
+	"[policy][score]1[/score][/policy]razón",
+	"[score]1[policy]a=b[/score][/policy]razón",
+	"[policy]a=1[/policy]ra[POLICY]a=2,b=\"x, y\"[/Policy]zón",
+	"[policy]dns=nxdom ain[/policy]",
+	"[score]10@l1[/score][score]x[/score]",
+	"[policy]a=1[policy]b=2[/policy][/policy]",
+	"[score]1[/score][score]2",
+	"[confidence]80[/confidence][lists]l1[/lists]razón[lists]l2",
+	"[sco[policy]x=1[/policy]re]1[/score]",
+	"[[score]]1[/score]]",
+}
+
+func TestFastParser(t *testing.T) {
+	var p reason.FastParser
+	for _, s := range fastSeeds {
+		checkFastParser(t, &p, s)
+	}
+}
+
+func FuzzFastParser(f *testing.F) {
+	for _, s := range fastSeeds {
+		f.Add(s)
+	}
+	var p reason.FastParser
+	f.Fuzz(func(t *testing.T, s string) {
+		checkFastParser(t, &p, s)
+	})
+}
+
+func checkFastParser(t *testing.T, p *reason.FastParser, s string) {
+	t.Helper()
+	p.Parse(s)
+	// score
+	wantScore, _, wantErr := reason.ExtractScore(s)
+	gotScore, gotErr := p.Score()
+	if gotScore != wantScore || !sameError(gotErr, wantErr) {
+		t.Errorf("Score(%q) want=%v,%v got=%v,%v", s, wantScore, wantErr, gotScore, gotErr)
+	}
+	// policy
+	wantPolicy, _, wantErr := reason.ExtractPolicy(s)
+	gotPolicy, gotErr := p.Policy()
+	if !sameError(gotErr, wantErr) {
+		t.Errorf("Policy(%q) want=%v got=%v", s, wantErr, gotErr)
+	} else if wantErr == nil {
+		if gotPolicy.String() != wantPolicy.String() {
+			t.Errorf("Policy(%q) want=%v got=%v", s, wantPolicy.String(), gotPolicy.String())
+		}
+		for _, field := range wantPolicy.Fields() {
+			want, _ := wantPolicy.Get(field)
+			if got, ok, _ := p.PolicyValue(field); !ok || got != want {
+				t.Errorf("PolicyValue(%q, %s) want=%v got=%v", s, field, want, got)
+			}
+		}
+	}
+	// text
+	want := reason.Clean(s)
+	if got := p.Text(); got != want {
+		t.Errorf("Text(%q) want=%q got=%q", s, want, got)
+	}
+	if got := string(p.AppendText(nil)); got != want {
+		t.Errorf("AppendText(%q) want=%q got=%q", s, want, got)
+	}
+}
+
+func sameError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Error() == b.Error()
+}
+
+func TestFastParserAllocs(t *testing.T) {
+	var p reason.FastParser
+	var buf []byte
+	s := "[score]10@l1[/score][policy]dns=nxdomain,event=high[/policy]domain in blacklist"
+	allocs := testing.AllocsPerRun(100, func() {
+		p.Parse(s)
+		p.Score()
+		p.PolicyValue("dns")
+		buf = p.AppendText(buf[:0])
+	})
+	if allocs > 0 {
+		t.Errorf("FastParser allocs want=0 got=%v", allocs)
+	}
+}
+
+const benchReason = "[score]10@l1[/score][policy]dns=nxdomain,event=high[/policy]domain in blacklist"
+
+func BenchmarkExtract(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reason.ExtractScore(benchReason)
+		p, _, _ := reason.ExtractPolicy(benchReason)
+		p.Get("dns")
+		reason.Clean(benchReason)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r, _ := reason.Parse(benchReason)
+		r.Policy.Get("dns")
+	}
+}
+
+func BenchmarkFastParser(b *testing.B) {
+	b.ReportAllocs()
+	var p reason.FastParser
+	var buf []byte
+	for i := 0; i < b.N; i++ {
+		p.Parse(benchReason)
+		p.Score()
+		p.PolicyValue("dns")
+		buf = p.AppendText(buf[:0])
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// reasonJSON is the json representation of a reason. Custom tags are stored
+// encoded by their codecs.
+type reasonJSON struct {
+	Text   string            `json:"text"`
+	Score  int               `json:"score"`
+	Scores []Score           `json:"scores,omitempty"`
+	Policy Policy            `json:"policy"`
+	Custom map[string]string `json:"custom,omitempty"`
+	Tags   []Tag             `json:"tags,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (r Reason) MarshalJSON() ([]byte, error) {
+	rj := reasonJSON{
+		Text:   r.Text,
+		Score:  r.Score,
+		Policy: r.Policy,
+		Tags:   r.Tags,
+	}
+	if r.withSources() {
+		rj.Scores = r.Scores
+	}
+	if len(r.Custom) > 0 {
+		rj.Custom = make(map[string]string, len(r.Custom))
+		for name, value := range r.Custom {
+			codec, ok := getCodec(name)
+			if !ok {
+				return nil, fmt.Errorf("reason: tag '%s' not registered", name)
+			}
+			encoded, err := encodeTag(codec, name, value)
+			if err != nil {
+				return nil, err
+			}
+			rj.Custom[name] = encoded
+		}
+	}
+	return json.Marshal(rj)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (r *Reason) UnmarshalJSON(data []byte) error {
+	rj := reasonJSON{Policy: NewPolicy()}
+	err := json.Unmarshal(data, &rj)
+	if err != nil {
+		return err
+	}
+	nr := Reason{
+		Text:   rj.Text,
+		Score:  rj.Score,
+		Scores: rj.Scores,
+		Policy: rj.Policy,
+		Tags:   rj.Tags,
+	}
+	for _, sc := range nr.Scores {
+		if sc.Source != "" && !isSource(sc.Source) {
+			return fmt.Errorf("reason: invalid score source '%s'", sc.Source)
+		}
+	}
+	if Clean(nr.Text) != nr.Text {
+		return fmt.Errorf("reason: text contains tags")
+	}
+	for _, t := range nr.Tags {
+		name := strings.ToLower(t.Name)
+		if !tagRegExp.MatchString(name) || name != t.Name {
+			return fmt.Errorf("reason: invalid tag name '%s'", t.Name)
+		}
+		// built-in and custom tags have their own fields
+		if _, ok := getCodec(name); ok || name == scoreTag || name == policyTag {
+			return fmt.Errorf("reason: tag '%s' not allowed in tags", t.Name)
+		}
+		if err := checkTagValue(t.Name, t.Value); err != nil {
+			return err
+		}
+	}
+	if len(rj.Custom) > 0 {
+		nr.Custom = make(map[string]interface{}, len(rj.Custom))
+		for name, encoded := range rj.Custom {
+			codec, ok := getCodec(name)
+			if !ok {
+				return fmt.Errorf("reason: tag '%s' not registered", name)
+			}
+			v, err := decodeTag(codec, tagBlock{name: name, value: encoded}, nil)
+			if err != nil {
+				return fmt.Errorf("reason: %v", err)
+			}
+			nr.Custom[name] = v
+		}
+	}
+	*r = nr
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Policy is encoded as an object.
+func (p Policy) MarshalJSON() ([]byte, error) {
+	if p.m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(p.m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Null is decoded as an empty
+// policy.
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	m := make(map[string]string)
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		m = make(map[string]string)
+	}
+	for field := range m {
+		if !isField(field) {
+			return fmt.Errorf("reason: invalid field '%s'", field)
+		}
+	}
+	p.m = m
+	return nil
+}
+
+// ToMap returns the json representation of the reason as a map that only
+// contains strings, float64 numbers, slices and maps, so it can be used to
+// build a protobuf Struct.
+func (r Reason) ToMap() (map[string]interface{}, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{})
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// FromMap returns the reason from a map returned by ToMap.
+func FromMap(m map[string]interface{}) (Reason, error) {
+	var r Reason
+	data, err := json.Marshal(m)
+	if err != nil {
+		return r, fmt.Errorf("reason: invalid map: %v", err)
+	}
+	err = json.Unmarshal(data, &r)
+	return r, err
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestReasonJSON(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want string
+	}{
+		{"razón", `{"text":"razón","score":0,"policy":{}}`},
+		{"[score]10[/score][policy]dns=nxdomain,msg=\"a, b\"[/policy]razón",
+			`{"text":"razón","score":10,"policy":{"dns":"nxdomain","msg":"a, b"}}`},
+		{"[score]10@l1[/score][score]5@l2[/score]razón",
+			`{"text":"razón","score":15,"scores":[{"value":10,"source":"l1"},{"value":5,"source":"l2"}],"policy":{}}`},
+		{"[lists]l1,l2[/lists][confidence]80[/confidence][ttl]60[/ttl]razón",
+			`{"text":"[ttl]60[/ttl]razón","score":0,"policy":{},"custom":{"confidence":"80","lists":"l1,l2"}}`},
+		{"[sig]k1:abc[/sig]razón",
+			`{"text":"razón","score":0,"policy":{},"tags":[{"name":"sig","value":"k1:abc"}]}`},
+	}
+	for _, test := range tests {
+		r, err := reason.Parse(test.in)
+		if err != nil {
+			t.Fatalf("Parse(%s) unexpected err: %v", test.in, err)
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("Marshal(%s) unexpected err: %v", test.in, err)
+		}
+		if string(data) != test.want {
+			t.Errorf("Marshal(%s) want=%s got=%s", test.in, test.want, data)
+		}
+		var got reason.Reason
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal(%s) unexpected err: %v", data, err)
+		}
+		if got.String() != r.String() {
+			t.Errorf("Unmarshal(%s) want=%v got=%v", data, r.String(), got.String())
+		}
+		// map form
+		m, err := r.ToMap()
+		if err != nil {
+			t.Fatalf("ToMap(%s) unexpected err: %v", test.in, err)
+		}
+		if _, ok := m["score"].(float64); !ok {
+			t.Errorf("ToMap(%s) score is not float64: %T", test.in, m["score"])
+		}
+		got, err = reason.FromMap(m)
+		if err != nil {
+			t.Fatalf("FromMap(%v) unexpected err: %v", m, err)
+		}
+		if got.String() != r.String() {
+			t.Errorf("FromMap(%v) want=%v got=%v", m, r.String(), got.String())
+		}
+	}
+}
+
+func TestReasonJSONErr(t *testing.T) {
+	var tests = []string{
+		`{"text":"razón","policy":{"1bad":"x"}}`,
+		`{"text":"razón","scores":[{"value":10,"source":"l 1"}]}`,
+		`{"text":"razón","custom":{"unknown":"x"}}`,
+		`{"text":"razón","custom":{"confidence":"high"}}`,
+		`{"text":"razón","tags":[{"name":"Bad Tag","value":"x"}]}`,
+		`{"text":1}`,
+		`{"text":"ok","policy":{},"tags":[{"name":"policy","value":"action=allow"}]}`,
+		`{"text":"ok","tags":[{"name":"score","value":"90"}]}`,
+		`{"text":"ok","tags":[{"name":"sig","value":"x[/sig][score]90[/score]"}]}`,
+		`{"text":"[policy]action=allow[/policy]ok"}`,
+	}
+	for _, test := range tests {
+		var r reason.Reason
+		if err := json.Unmarshal([]byte(test), &r); err == nil {
+			t.Errorf("Unmarshal(%s) expected err", test)
+		}
+	}
+	// null policy is empty
+	for _, data := range []string{`{"text":"razón","policy":null}`, `{"text":"razón"}`} {
+		var r reason.Reason
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			t.Fatalf("Unmarshal(%s) unexpected err: %v", data, err)
+		}
+		if err := r.Policy.Set("dns", "nxdomain"); err != nil || r.Policy.Empty() {
+			t.Errorf("Unmarshal(%s) policy can't be set: %v", data, err)
+		}
+	}
+	var p reason.Policy
+	if err := json.Unmarshal([]byte("null"), &p); err != nil || p.Set("dns", "nxdomain") != nil {
+		t.Errorf("Unmarshal(null) policy can't be set: %v", err)
+	}
+	m := map[string]interface{}{"text": "razón", "score": 10, "policy": map[string]interface{}{"dns": "nxdomain"}}
+	r, err := reason.FromMap(m)
+	if err != nil {
+		t.Fatalf("FromMap(%v) unexpected err: %v", m, err)
+	}
+	if want := "[score]10[/score][policy]dns=nxdomain[/policy]razón"; r.String() != want {
+		t.Errorf("FromMap(%v) want=%v got=%v", m, want, r.String())
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// MergeFn sets definition for functions that merge the current value of a
+// field with a new value. It returns the resulting value.
+type MergeFn func(current, value string) (string, error)
+
+// MergeFirst keeps the current value.
+func MergeFirst(current, value string) (string, error) {
+	return current, nil
+}
+
+// MergeLast replaces the current value.
+func MergeLast(current, value string) (string, error) {
+	return value, nil
+}
+
+// MergeMax keeps the greater numeric value.
+func MergeMax(current, value string) (string, error) {
+	c, v, err := parseNumbers(current, value)
+	if err != nil {
+		return current, err
+	}
+	if v > c {
+		return value, nil
+	}
+	return current, nil
+}
+
+// MergeMin keeps the lower numeric value.
+func MergeMin(current, value string) (string, error) {
+	c, v, err := parseNumbers(current, value)
+	if err != nil {
+		return current, err
+	}
+	if v < c {
+		return value, nil
+	}
+	return current, nil
+}
+
+// Precedence returns a merge function for enumerated values ordered from
+// lowest to highest precedence. For example, Precedence("allow", "log",
+// "block") makes the most restrictive action win.
+func Precedence(order ...string) MergeFn {
+	rank := make(map[string]int, len(order))
+	for i, v := range order {
+		rank[v] = i
+	}
+	return func(current, value string) (string, error) {
+		c, ok := rank[current]
+		if !ok {
+			return current, fmt.Errorf("value '%s' without precedence", current)
+		}
+		v, ok := rank[value]
+		if !ok {
+			return current, fmt.Errorf("value '%s' without precedence", value)
+		}
+		if v > c {
+			return value, nil
+		}
+		return current, nil
+	}
+}
+
+// Merger merges policies using strategies selected per field.
+type Merger struct {
+	// Default strategy for fields without strategy, if nil MergeLast is used
+	Default MergeFn
+	// Fields stores strategies per field
+	Fields map[string]MergeFn
+}
+
+// Conflict stores information about a field with different values while
+// merging. Sources are the indexes of the policies passed to Merge.
+type Conflict struct {
+	Field string
+	// Source and Value kept in the field
+	Source int
+	Value  string
+	// Overridden source and value
+	Overridden      int
+	OverriddenValue string
+}
+
+// String implements fmt.Stringer.
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: value '%s' from source %v overrides '%s' from source %v",
+		c.Field, c.Value, c.Source, c.OverriddenValue, c.Overridden)
+}
+
+// Merge returns a new policy merging the policies in order. It returns the
+// conflicts found, the values that are equal are not reported.
+func (m Merger) Merge(policies ...Policy) (Policy, []Conflict, error) {
+	merged := NewPolicy()
+	sources := make(map[string]int)
+	var conflicts []Conflict
+	for i, policy := range policies {
+		fields := policy.Fields()
+		sort.Strings(fields)
+		for _, field := range fields {
+			value := policy.m[field]
+			current, ok := merged.m[field]
+			if !ok {
+				merged.m[field] = value
+				sources[field] = i
+				continue
+			}
+			if current == value {
+				continue
+			}
+			result, err := m.strategy(field)(current, value)
+			if err != nil {
+				return merged, conflicts, fmt.Errorf("merging field '%s': %v", field, err)
+			}
+			c := Conflict{Field: field, Source: i, Value: result}
+			if result == current {
+				c.Source, c.Overridden, c.OverriddenValue = sources[field], i, value
+			} else {
+				c.Overridden, c.OverriddenValue = sources[field], current
+				sources[field] = i
+			}
+			merged.m[field] = result
+			conflicts = append(conflicts, c)
+		}
+	}
+	return merged, conflicts, nil
+}
+
+func (m Merger) strategy(field string) MergeFn {
+	if fn, ok := m.Fields[field]; ok && fn != nil {
+		return fn
+	}
+	if m.Default != nil {
+		return m.Default
+	}
+	return MergeLast
+}
+
+func parseNumbers(a, b string) (float64, float64, error) {
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number '%s'", a)
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid number '%s'", b)
+	}
+	return x, y, nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestMergerMerge(t *testing.T) {
+	merger := reason.Merger{
+		Fields: map[string]reason.MergeFn{
+			"action": reason.Precedence("allow", "log", "block"),
+			"ttl":    reason.MergeMin,
+			"score":  reason.MergeMax,
+			"dns":    reason.MergeFirst,
+		},
+	}
+	var tests = []struct {
+		in            []string
+		wantErr       bool
+		want          string
+		wantConflicts int
+	}{
+		{[]string{"[policy]action=log[/policy]", "[policy]action=block[/policy]", "[policy]action=allow[/policy]"},
+			false, "[policy]action=block[/policy]", 2},
+		{[]string{"[policy]ttl=60,score=10[/policy]", "[policy]ttl=30,score=5[/policy]"},
+			false, "[policy]score=10,ttl=30[/policy]", 2},
+		{[]string{"[policy]dns=nxdomain,event=low[/policy]", "[policy]dns=checkip,event=high[/policy]"},
+			false, "[policy]dns=nxdomain,event=high[/policy]", 2},
+		{[]string{"[policy]dns=nxdomain[/policy]", "[policy]dns=nxdomain[/policy]"},
+			false, "[policy]dns=nxdomain[/policy]", 0},
+		{[]string{"[policy]action=log[/policy]", "[policy]action=drop[/policy]"},
+			true, "", 0},
+		{[]string{"[policy]ttl=60[/policy]", "[policy]ttl=never[/policy]"},
+			true, "", 0},
+	}
+	for _, test := range tests {
+		policies := make([]reason.Policy, 0, len(test.in))
+		for _, s := range test.in {
+			p, _, err := reason.ExtractPolicy(s)
+			if err != nil {
+				t.Fatalf("ExtractPolicy(%s) unexpected err: %v", s, err)
+			}
+			policies = append(policies, p)
+		}
+		got, conflicts, err := merger.Merge(policies...)
+		if err != nil && !test.wantErr {
+			t.Errorf("Merge(%v) unexpected err: %v", test.in, err)
+			continue
+		} else if err == nil && test.wantErr {
+			t.Errorf("Merge(%v) expected err", test.in)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("Merge(%v) want=%v got=%v", test.in, test.want, got.String())
+		}
+		if len(conflicts) != test.wantConflicts {
+			t.Errorf("Merge(%v) conflicts want=%v got=%v", test.in, test.wantConflicts, conflicts)
+		}
+	}
+}
+
+func TestMergerConflicts(t *testing.T) {
+	merger := reason.Merger{Fields: map[string]reason.MergeFn{"action": reason.Precedence("allow", "log", "block")}}
+	p0, _, _ := reason.ExtractPolicy("[policy]action=log[/policy]")
+	p1, _, _ := reason.ExtractPolicy("[policy]action=block[/policy]")
+	p2, _, _ := reason.ExtractPolicy("[policy]action=allow[/policy]")
+	_, conflicts, err := merger.Merge(p0, p1, p2)
+	if err != nil {
+		t.Fatalf("Merge unexpected err: %v", err)
+	}
+	want := []reason.Conflict{
+		{Field: "action", Source: 1, Value: "block", Overridden: 0, OverriddenValue: "log"},
+		{Field: "action", Source: 1, Value: "block", Overridden: 2, OverriddenValue: "allow"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("Merge conflicts want=%v got=%v", want, conflicts)
+	}
+	for i := range want {
+		if conflicts[i] != want[i] {
+			t.Errorf("Merge conflict want=%v got=%v", want[i], conflicts[i])
+		}
+	}
+}
@@ -5,11 +5,16 @@ package reason
 import (
 	"errors"
 	"fmt"
-	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
-// Policy stores fields and values (both are strings). Warning, it's unsafe.
+// Policy stores fields and values (both are strings). Warning, it's unsafe:
+// copies of a policy share its values, use Clone to get an independent copy
+// or SyncPolicy to share it between goroutines.
 type Policy struct {
 	m map[string]string
 }
@@ -22,35 +27,207 @@ func NewPolicy() Policy {
 // FromString loads from a encoded string the values of a policy.
 // String must be in the format:
 //  [policy]field1=value1,field2=value2[/policy]
+// Values with characters other than letters, digits and '_.:@/' must be
+// enclosed in double quotes, using backslash escapes (\\, \", \n, \r, \t
+// and \xHH).
 func (p *Policy) FromString(s string) error {
-	slow := strings.ToLower(s)
-	if !strings.HasPrefix(slow, "[policy]") || !strings.HasSuffix(slow, "[/policy]") {
+	blocks, rest := scanTags(s, policyTag)
+	if len(blocks) != 1 || rest != "" {
 		return errors.New("invalid string")
 	}
-	s = s[8 : len(slow)-9] //len('[policy]')==8, len('[/policy]')==9
+	return p.fromValue(blocks[0].value)
+}
 
-	//load fields an values
+// fromValue loads the values of a policy from the content of a policy tag.
+func (p *Policy) fromValue(s string) error {
 	m := make(map[string]string, 0)
-	args := strings.Split(s, ",")
-	for _, arg := range args {
-		tmps := strings.SplitN(arg, "=", 2)
-		field := strings.TrimSpace(tmps[0])
-		value := ""
-		if len(tmps) > 1 {
-			value = strings.TrimSpace(tmps[1])
-		}
-		if !fieldRegExp.MatchString(field) {
-			return fmt.Errorf("invalid field '%s'", field)
-		}
-		if !valueRegExp.MatchString(value) {
-			return fmt.Errorf("invalid value '%s'", value)
+	for i := 0; i >= 0; {
+		var field, raw string
+		var err error
+		field, raw, i, err = nextField(s, i)
+		if err != nil {
+			return err
 		}
-		m[field] = value
+		m[field] = decodeValue(raw)
 	}
 	p.m = m
 	return nil
 }
 
+// nextField reads the field starting at position i of the content of a
+// policy tag. It returns the field, its raw value (quoted values keep their
+// quotes) and the position of the next field, -1 if it was the last one.
+func nextField(s string, i int) (field, raw string, next int, err error) {
+	j := i
+	for j < len(s) && s[j] != '=' && s[j] != ',' {
+		j++
+	}
+	field = strings.TrimSpace(s[i:j])
+	if !isField(field) {
+		return "", "", -1, fmt.Errorf("invalid field '%s'", field)
+	}
+	if j < len(s) && s[j] == '=' {
+		raw, j, err = readValue(s, j+1)
+		if err != nil {
+			return "", "", -1, err
+		}
+	}
+	if j >= len(s) {
+		return field, raw, -1, nil
+	}
+	return field, raw, j + 1, nil //skip separator
+}
+
+// readValue reads a quoted or unquoted value starting at position i. It
+// returns the raw value and the position of the next separator.
+func readValue(s string, i int) (string, int, error) {
+	trimmed := strings.TrimLeftFunc(s[i:], unicode.IsSpace)
+	if strings.HasPrefix(trimmed, "\"") {
+		start := len(s) - len(trimmed)
+		n, err := quotedLen(trimmed)
+		if err != nil {
+			return "", start, fmt.Errorf("invalid value '%s': %v", trimmed, err)
+		}
+		j := start + n
+		for j < len(s) && s[j] != ',' {
+			if !isSpace(s[j]) {
+				return "", j, fmt.Errorf("invalid value '%s'", trimmed)
+			}
+			j++
+		}
+		return trimmed[:n], j, nil
+	}
+	j := strings.IndexByte(s[i:], ',')
+	if j < 0 {
+		j = len(s)
+	} else {
+		j = i + j
+	}
+	value := strings.TrimSpace(s[i:j])
+	if !isPlainValue(value) {
+		return "", j, fmt.Errorf("invalid value '%s'", value)
+	}
+	return value, j, nil
+}
+
+// decodeValue returns the value of a raw value returned by readValue.
+func decodeValue(raw string) string {
+	if strings.HasPrefix(raw, "\"") {
+		return unquoteValue(raw)
+	}
+	return raw
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// quoteValue returns the value quoted if it contains characters that are not
+// allowed in unquoted values. Brackets are escaped, so a quoted value never
+// contains tags.
+func quoteValue(v string) string {
+	if isPlainValue(v) {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '\\' || c == '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '[' || c == ']' || c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quotedLen returns the length of the quoted value at the beginning of s,
+// checking its escapes.
+func quotedLen(s string) (int, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return i, errors.New("unterminated escape")
+			}
+			switch s[i] {
+			case '\\', '"', 'n', 'r', 't':
+			case 'x':
+				if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+					return i, errors.New("invalid hex escape")
+				}
+				i += 2
+			default:
+				return i, fmt.Errorf("invalid escape '\\%c'", s[i])
+			}
+		}
+	}
+	return len(s), errors.New("unterminated quoted value")
+}
+
+// unquoteValue decodes a quoted value checked by quotedLen.
+func unquoteValue(s string) string {
+	var b strings.Builder
+	for i := 1; i < len(s)-1; i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'x':
+			h, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			b.WriteByte(byte(h))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// Clone returns a copy of the policy that doesn't share values.
+func (p Policy) Clone() Policy {
+	m := make(map[string]string, len(p.m))
+	for k, v := range p.m {
+		m[k] = v
+	}
+	return Policy{m: m}
+}
+
 // Empty returns true if the policy is empty.
 func (p Policy) Empty() bool {
 	return len(p.m) == 0
@@ -62,18 +239,93 @@ func (p Policy) Get(field string) (string, bool) {
 	return value, ok
 }
 
-// Set a new field policy or modify existing value.
+// Set a new field policy or modify existing value. Values can contain any
+// character, they will be quoted when encoded if needed.
 func (p Policy) Set(field, value string) error {
-	if !fieldRegExp.MatchString(field) {
+	if !isField(field) {
 		return errors.New("invalid field")
 	}
-	if !valueRegExp.MatchString(value) {
-		return errors.New("invalid value")
-	}
 	p.m[field] = value
 	return nil
 }
 
+// Delete removes the field from the policy.
+func (p Policy) Delete(field string) {
+	delete(p.m, field)
+}
+
+// GetInt returns the value of the field as int, ok if exists.
+func (p Policy) GetInt(field string) (value int, ok bool, err error) {
+	v, ok := p.m[field]
+	if ok {
+		value, err = strconv.Atoi(v)
+		if err != nil {
+			err = fmt.Errorf("invalid int '%s'", field)
+		}
+	}
+	return
+}
+
+// GetBool returns the value of the field as bool, ok if exists.
+func (p Policy) GetBool(field string) (value bool, ok bool, err error) {
+	v, ok := p.m[field]
+	if ok {
+		value, err = strconv.ParseBool(v)
+		if err != nil {
+			err = fmt.Errorf("invalid bool '%s'", field)
+		}
+	}
+	return
+}
+
+// GetDuration returns the value of the field as duration, ok if exists.
+func (p Policy) GetDuration(field string) (value time.Duration, ok bool, err error) {
+	v, ok := p.m[field]
+	if ok {
+		value, err = time.ParseDuration(v)
+		if err != nil {
+			err = fmt.Errorf("invalid duration '%s'", field)
+		}
+	}
+	return
+}
+
+// GetList returns the value of the field as a list of comma separated
+// strings, ok if exists.
+func (p Policy) GetList(field string) (value []string, ok bool) {
+	v, ok := p.m[field]
+	if ok {
+		value = splitList(v)
+	}
+	return
+}
+
+// SetInt sets the value of the field as int.
+func (p Policy) SetInt(field string, value int) error {
+	return p.Set(field, strconv.Itoa(value))
+}
+
+// SetBool sets the value of the field as bool.
+func (p Policy) SetBool(field string, value bool) error {
+	return p.Set(field, strconv.FormatBool(value))
+}
+
+// SetDuration sets the value of the field as duration.
+func (p Policy) SetDuration(field string, value time.Duration) error {
+	return p.Set(field, value.String())
+}
+
+// SetList sets the value of the field as a list of strings. Items can't
+// contain commas.
+func (p Policy) SetList(field string, value []string) error {
+	for _, item := range value {
+		if strings.Contains(item, ",") {
+			return errors.New("invalid list item")
+		}
+	}
+	return p.Set(field, strings.Join(value, ","))
+}
+
 // Merge policies.
 func (p Policy) Merge(policies ...Policy) {
 	for _, policy := range policies {
@@ -92,97 +344,90 @@ func (p Policy) Fields() []string {
 	return fields
 }
 
-// String returns the policy encoded as string.
+// String returns the policy encoded as string. Fields are sorted, so the
+// encoding is canonical.
 func (p Policy) String() string {
 	if len(p.m) == 0 {
 		return ""
 	}
-	s := "[policy]"
-	sep := false
-	for k, v := range p.m {
-		if sep {
-			s = s + ","
-		} else {
-			sep = true
+	fields := p.Fields()
+	sort.Strings(fields)
+	var b strings.Builder
+	b.WriteString("[policy]")
+	for i, k := range fields {
+		if i > 0 {
+			b.WriteString(",")
 		}
-		s = s + fmt.Sprintf("%s=%s", k, v)
+		b.WriteString(k)
+		b.WriteString("=")
+		b.WriteString(quoteValue(p.m[k]))
 	}
-	s = s + "[/policy]"
-	return s
+	b.WriteString("[/policy]")
+	return b.String()
 }
 
 // WithPolicy inserts a policy inside a reason string. If there is a policy
 // inside, WithPolicy will replace it.
 func WithPolicy(policy Policy, s string) string {
+	_, s = scanTags(s, policyTag)
 	if policy.Empty() {
-		return cleanPolicy(s)
+		return s
 	}
-	return fmt.Sprintf("%s%s", policy.String(), cleanPolicy(s))
+	return fmt.Sprintf("%s%s", policy.String(), s)
 }
 
 // ExtractPolicy extracts a policy from a reason string. It returns the policy,
 // an string reason without the policy and error.
 func ExtractPolicy(s string) (Policy, string, error) {
-	policies, reason := extractPolicyStr(s)
+	policies, reason := scanTags(s, policyTag)
 	p := NewPolicy()
-	if len(policies) > 0 {
-		for _, policyStr := range policies {
-			np := NewPolicy()
-			err := np.FromString(policyStr)
-			if err != nil {
-				return p, reason, fmt.Errorf("invalid policy '%s': %v", policyStr, err)
-			}
-			p.Merge(np)
+	for _, policy := range policies {
+		np := NewPolicy()
+		err := np.fromValue(policy.value)
+		if err != nil {
+			return p, reason, fmt.Errorf("invalid policy '%s': %v", tagToString(policyTag, policy.value), err)
 		}
+		p.Merge(np)
 	}
 	return p, reason, nil
 }
 
-func cleanPolicy(s string) (rest string) {
-	rest = ""
-	for len(s) > 0 {
-		slow := strings.ToLower(s)
-		first := strings.Index(slow, "[policy]")
-		last := strings.Index(slow, "[/policy]")
-		if first < 0 || last < 0 {
-			rest = rest + s
-			return
-		}
-		if last < first {
-			//len("[/policy]") == 9
-			rest = rest + s[:last+9]
-			s = s[last+9:]
-			continue
+const policyTag = "policy"
+
+// isField returns true if s is a valid field: a letter followed by letters,
+// digits, '_' or '.'.
+func isField(s string) bool {
+	if s == "" || !isLetter(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !isLetter(c) && !isDigit(c) && c != '_' && c != '.' {
+			return false
 		}
-		rest = rest + s[:first]
-		s = s[last+9:]
 	}
-	return
+	return true
 }
 
-func extractPolicyStr(s string) (policies []string, rest string) {
-	rest = ""
-	policies = make([]string, 0)
-	for len(s) > 0 {
-		slow := strings.ToLower(s)
-		first := strings.Index(slow, "[policy]")
-		last := strings.Index(slow, "[/policy]")
-		if first < 0 || last < 0 {
-			rest = rest + s
-			return
-		}
-		if last < first {
-			//len("[/policy]") == 9
-			rest = rest + s[:last+9]
-			s = s[last+9:]
-			continue
+// isPlainValue returns true if s can be encoded without quotes.
+func isPlainValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isLetter(c) && !isDigit(c) && strings.IndexByte("_.:@/", c) < 0 {
+			return false
 		}
-		policies = append(policies, s[first:last+9])
-		rest = rest + s[:first]
-		s = s[last+9:]
 	}
-	return
+	return true
 }
 
-var fieldRegExp, _ = regexp.Compile(`^[A-Za-z][A-Za-z0-9_\.]*$`)
-var valueRegExp, _ = regexp.Compile(`^[A-Za-z0-9_\.,:@/]*$`)
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
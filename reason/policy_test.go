@@ -3,7 +3,10 @@
 package reason_test
 
 import (
+	"fmt"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/luids-io/core/reason"
 )
@@ -39,6 +42,10 @@ func TestFromString(t *testing.T) {
 		{"[policy]dns= nxdomain, log =,otro=kk[/policy]", "log", ""},
 		{"[policy]dns= nxdomain, log =,otro=kk[/policy]", "otro", "kk"},
 		{"[policy]dns=ip4:127.0.0.1,event=high[/policy]", "dns", "ip4:127.0.0.1"},
+		{`[policy]msg="hello, world",event=high[/policy]`, "msg", "hello, world"},
+		{`[policy]msg= "a=b" ,event=high[/policy]`, "msg", "a=b"},
+		{`[policy]msg="\x5blist\x5d \"q\" \\"[/policy]`, "msg", `[list] "q" \`},
+		{`[policy]msg=""[/policy]`, "msg", ""},
 	}
 	for _, test := range tests {
 		p := reason.NewPolicy()
@@ -67,6 +74,11 @@ func TestFromStringErr(t *testing.T) {
 		{"[policy]dns-=nxdomain[/policy]", true},
 		{"[policy]dns=nxdom ain[/policy]", true},
 		{"[policy]dns=nxdomain[/policy]", false},
+		{`[policy]dns="nxdom ain"[/policy]`, false},
+		{`[policy]dns="nxdomain[/policy]`, true},
+		{`[policy]dns="nxdomain"x[/policy]`, true},
+		{`[policy]dns="nx\qdomain"[/policy]`, true},
+		{`[policy]dns="nx\x5"[/policy]`, true},
 	}
 	for _, test := range tests {
 		p := reason.NewPolicy()
@@ -102,3 +114,104 @@ func TestExtractPolicy(t *testing.T) {
 		}
 	}
 }
+
+func TestPolicyString(t *testing.T) {
+	var tests = []struct {
+		field string
+		value string
+		want  string
+	}{
+		{"dns", "nxdomain", "[policy]dns=nxdomain[/policy]"},
+		{"dns", "ip4:127.0.0.1", "[policy]dns=ip4:127.0.0.1[/policy]"},
+		{"msg", "a,b", `[policy]msg="a,b"[/policy]`},
+		{"msg", "[/policy]", `[policy]msg="\x5b/policy\x5d"[/policy]`},
+		{"msg", "say \"hi\"\n", `[policy]msg="say \"hi\"\n"[/policy]`},
+	}
+	for _, test := range tests {
+		p := reason.NewPolicy()
+		if err := p.Set(test.field, test.value); err != nil {
+			t.Fatalf("Set(%s,%s) unexpected err: %v", test.field, test.value, err)
+		}
+		if got := p.String(); got != test.want {
+			t.Errorf("String() want=%v got=%v", test.want, got)
+		}
+	}
+}
+
+func TestPolicyDelete(t *testing.T) {
+	p := reason.NewPolicy()
+	p.Set("dns", "nxdomain")
+	p.Set("ttl", "60")
+	p.Delete("ttl")
+	p.Delete("unknown")
+	if want := "[policy]dns=nxdomain[/policy]"; p.String() != want {
+		t.Errorf("Delete want=%v got=%v", want, p.String())
+	}
+}
+
+func TestPolicyRoundTrip(t *testing.T) {
+	// random bytes, so values contain separators, quotes and brackets
+	f := func(values [][]byte) bool {
+		p := reason.NewPolicy()
+		for i, v := range values {
+			p.Set(fmt.Sprintf("field%d", i), string(v))
+		}
+		if p.Empty() {
+			return true
+		}
+		got := reason.NewPolicy()
+		if err := got.FromString(p.String()); err != nil {
+			t.Logf("FromString(%s): %v", p.String(), err)
+			return false
+		}
+		if got.String() != p.String() {
+			return false
+		}
+		for i, v := range values {
+			if gotv, _ := got.Get(fmt.Sprintf("field%d", i)); gotv != string(v) {
+				return false
+			}
+		}
+		// must survive inside a reason string too
+		ep, rest, err := reason.ExtractPolicy(reason.WithPolicy(p, "razón"))
+		return err == nil && rest == "razón" && ep.String() == p.String()
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPolicyTyped(t *testing.T) {
+	p := reason.NewPolicy()
+	p.SetInt("ttl", 60)
+	p.SetBool("log", true)
+	p.SetDuration("cache", 90*time.Second)
+	p.SetList("lists", []string{"l1", "l2"})
+	p.Set("bad", "x")
+	if err := p.SetList("lists", []string{"a,b"}); err == nil {
+		t.Error("SetList expected err")
+	}
+	// encode and decode
+	got, _, err := reason.ExtractPolicy(p.String())
+	if err != nil {
+		t.Fatalf("ExtractPolicy(%s) unexpected err: %v", p.String(), err)
+	}
+	if v, ok, err := got.GetInt("ttl"); !ok || err != nil || v != 60 {
+		t.Errorf("GetInt(ttl) = %v, %v, %v", v, ok, err)
+	}
+	if v, ok, err := got.GetBool("log"); !ok || err != nil || !v {
+		t.Errorf("GetBool(log) = %v, %v, %v", v, ok, err)
+	}
+	if v, ok, err := got.GetDuration("cache"); !ok || err != nil || v != 90*time.Second {
+		t.Errorf("GetDuration(cache) = %v, %v, %v", v, ok, err)
+	}
+	if v, ok := got.GetList("lists"); !ok || len(v) != 2 || v[1] != "l2" {
+		t.Errorf("GetList(lists) = %v, %v", v, ok)
+	}
+	if _, ok, err := got.GetInt("bad"); !ok || err == nil {
+		t.Errorf("GetInt(bad) expected err")
+	}
+	if _, ok, err := got.GetBool("notexists"); ok || err != nil {
+		t.Errorf("GetBool(notexists) = %v, %v", ok, err)
+	}
+}
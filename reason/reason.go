@@ -5,7 +5,240 @@
 // This package is a work in progress and makes no API stability promises.
 package reason
 
-// Clean removes policy and other stuff from a reason string
+import (
+	"fmt"
+	"strings"
+)
+
+// Reason stores the decoded data of a reason string.
+type Reason struct {
+	// Text is the reason string without known tags, as returned by Clean
+	Text string
+	// Score is the sum of all scores in the reason string
+	Score int
+	// Scores stores each score found in the reason string
+	Scores []Score
+	// Policy is the result of merging all policies in the reason string
+	Policy Policy
+	// Custom stores the decoded values of registered custom tags
+	Custom map[string]interface{}
+	// Tags stores other known tags without codec, like signatures
+	Tags []Tag
+}
+
+// Tag stores the name and the raw value of a tag.
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Parse decodes a reason string. Only built-in and registered tags are
+// extracted, like Clean does: registered custom tags are decoded into Custom
+// and other tags not registered are kept in Text.
+func Parse(s string) (Reason, error) {
+	blocks, rest := scanKnown(s)
+	return parseBlocks(blocks, rest)
+}
+
+// parseBlocks decodes the blocks of known tags found by scanTags.
+func parseBlocks(blocks []tagBlock, rest string) (Reason, error) {
+	r := Reason{Policy: NewPolicy()}
+	r.Text = rest
+	for _, b := range blocks {
+		switch b.name {
+		case scoreTag:
+			sc, err := scoreFromValue(b.value)
+			if err != nil {
+				return r, fmt.Errorf("invalid score '%s': %v", tagToString(b.name, b.value), err)
+			}
+			r.Score = r.Score + sc.Value
+			r.Scores = append(r.Scores, sc)
+		case policyTag:
+			np := NewPolicy()
+			err := np.fromValue(b.value)
+			if err != nil {
+				return r, fmt.Errorf("invalid policy '%s': %v", tagToString(b.name, b.value), err)
+			}
+			r.Policy.Merge(np)
+		default:
+			codec, ok := getCodec(b.name)
+			if !ok {
+				r.Tags = append(r.Tags, Tag{Name: b.name, Value: b.value})
+				continue
+			}
+			if r.Custom == nil {
+				r.Custom = make(map[string]interface{})
+			}
+			v, err := decodeTag(codec, b, r.Custom[b.name])
+			if err != nil {
+				return r, err
+			}
+			r.Custom[b.name] = v
+		}
+	}
+	return r, nil
+}
+
+// Encode returns the reason in its canonical encoding: score, policy, custom
+// tags sorted by name, other tags and text. If Scores has sources and its sum
+// is equal to Score, each score is encoded, otherwise only Score is encoded.
+// Scores with sources can't be parsed by versions prior to sources, to keep
+// compatibility clear the sources or the Scores slice.
+func (r Reason) Encode() (string, error) {
+	var b strings.Builder
+	if r.withSources() {
+		for _, sc := range r.Scores {
+			if sc.Source != "" || sc.Value != 0 {
+				b.WriteString(sc.String())
+			}
+		}
+	} else if r.Score != 0 {
+		b.WriteString(scoreToString(r.Score))
+	}
+	if !r.Policy.Empty() {
+		b.WriteString(r.Policy.String())
+	}
+	if len(r.Custom) > 0 {
+		custom, err := encodeTags(r.Custom)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(custom)
+	}
+	for _, t := range r.Tags {
+		if err := checkTagValue(t.Name, t.Value); err != nil {
+			return "", err
+		}
+		b.WriteString(tagToString(t.Name, t.Value))
+	}
+	b.WriteString(r.Text)
+	return b.String(), nil
+}
+
+// String returns the reason in its canonical encoding. If custom tags can't
+// be encoded, they are omitted.
+func (r Reason) String() string {
+	s, err := r.Encode()
+	if err != nil {
+		r.Custom = nil
+		s, _ = r.Encode()
+	}
+	return s
+}
+
+func (r Reason) withSources() bool {
+	sum, sources := 0, false
+	for _, sc := range r.Scores {
+		sum = sum + sc.Value
+		sources = sources || sc.Source != ""
+	}
+	return sources && sum == r.Score
+}
+
+// Clean removes policy, score, registered custom tags and other stuff from a
+// reason string
 func Clean(reason string) string {
-	return cleanScore(cleanPolicy(reason))
+	_, rest := scanKnown(reason)
+	return rest
+}
+
+// scanKnown extracts the blocks of known tags. Removing tags can join the
+// surrounding text into a new tag, so it scans the rest of the string again
+// until nothing is removed. Offsets of blocks found after the first scan are
+// relative to the rest of the previous scan.
+func scanKnown(s string) (blocks []tagBlock, rest string) {
+	names := knownTags()
+	blocks, rest = scanTags(s, names...)
+	for more := blocks; len(more) > 0; {
+		more, rest = scanTags(rest, names...)
+		blocks = append(blocks, more...)
+	}
+	return blocks, rest
+}
+
+// tagBlock stores a tag found by scanTags.
+type tagBlock struct {
+	name   string
+	value  string
+	offset int // position of the open tag
+	end    int // position after the close tag
+}
+
+// scanTags scans the string looking for blocks in the format
+// [name]value[/name] (case insensitive). If names are passed, only these
+// tags are extracted. It returns the blocks found and the rest of the string.
+func scanTags(s string, names ...string) (blocks []tagBlock, rest string) {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(s); {
+		idx := strings.IndexByte(s[i:], '[')
+		if idx < 0 {
+			break
+		}
+		open := i + idx
+		name, end := openTag(s, open)
+		if name == "" || !acceptTag(name, names) {
+			i = open + 1
+			continue
+		}
+		cstart, cend := closeTag(s, end, name)
+		if cstart < 0 {
+			i = open + 1
+			continue
+		}
+		blocks = append(blocks, tagBlock{name: name, value: s[end:cstart], offset: open, end: cend})
+		b.WriteString(s[last:open])
+		last = cend
+		i = cend
+	}
+	if last == 0 {
+		return blocks, s
+	}
+	b.WriteString(s[last:])
+	return blocks, b.String()
+}
+
+// openTag returns the lower case name of the open tag at position i and the
+// position after it. If there is not a valid open tag, name is empty.
+func openTag(s string, i int) (name string, end int) {
+	j := i + 1
+	for ; j < len(s) && s[j] != ']'; j++ {
+		c := s[j]
+		if !isLetter(c) && (j == i+1 || (!isDigit(c) && c != '_')) {
+			return "", i
+		}
+	}
+	if j == i+1 || j >= len(s) {
+		return "", i
+	}
+	return strings.ToLower(s[i+1 : j]), j + 1
+}
+
+// closeTag returns the position of the first close tag for name found
+// after i. If it doesn't exist, start is -1.
+func closeTag(s string, i int, name string) (start, end int) {
+	size := len(name) + 3 // len("[/]") == 3
+	for j := i; j+size <= len(s); j++ {
+		if s[j] == '[' && s[j+1] == '/' && s[j+size-1] == ']' &&
+			strings.EqualFold(s[j+2:j+size-1], name) {
+			return j, j + size
+		}
+	}
+	return -1, -1
+}
+
+func acceptTag(name string, names []string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tagToString(name, value string) string {
+	return fmt.Sprintf("[%s]%s[/%s]", name, value, name)
 }
@@ -0,0 +1,106 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in         string
+		wantErr    bool
+		wantText   string
+		wantScore  int
+		wantPolicy string
+		wantTags   int
+	}{
+		{"razón", false, "razón", 0, "", 0},
+		{"[score]10[/score]razón", false, "razón", 10, "", 0},
+		{"r[score]1[/score]a[score]2[/score]zón", false, "razón", 3, "", 0},
+		{"[policy]dns=nxdomain[/policy]razón", false, "razón", 0, "[policy]dns=nxdomain[/policy]", 0},
+		{"[SCORE]5[/score][policy]b=2,a=1[/POLICY]razón", false, "razón", 5, "[policy]a=1,b=2[/policy]", 0},
+		{"[policy]a=1[/policy]ra[policy]a=2[/policy]zón", false, "razón", 0, "[policy]a=2[/policy]", 0},
+		{"[src]list1[/src]razón[TTL]60[/ttl]", false, "[src]list1[/src]razón[TTL]60[/ttl]", 0, "", 0},
+		{"ra[x][score]1[/score][/x]zón", false, "ra[x][/x]zón", 1, "", 0},
+		{"[sig]k1:abc[/sig]razón", false, "razón", 0, "", 1},
+		{"[/score]razón[score]", false, "[/score]razón[score]", 0, "", 0},
+		{"[score]1.5[/score]razón", true, "razón", 0, "", 0},
+		{"[policy]dns-=nxdomain[/policy]razón", true, "razón", 0, "", 0},
+	}
+	for _, test := range tests {
+		got, err := reason.Parse(test.in)
+		if err != nil && !test.wantErr {
+			t.Errorf("Parse(%s) unexpected err: %v", test.in, err)
+			continue
+		} else if err == nil && test.wantErr {
+			t.Errorf("Parse(%s) expected err", test.in)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if got.Text != test.wantText {
+			t.Errorf("Parse(%s) text want=%v got=%v", test.in, test.wantText, got.Text)
+		}
+		if clean := reason.Clean(test.in); got.Text != clean {
+			t.Errorf("Parse(%s) text=%v differs from Clean=%v", test.in, got.Text, clean)
+		}
+		if got.Score != test.wantScore {
+			t.Errorf("Parse(%s) score want=%v got=%v", test.in, test.wantScore, got.Score)
+		}
+		if got.Policy.String() != test.wantPolicy {
+			t.Errorf("Parse(%s) policy want=%v got=%v", test.in, test.wantPolicy, got.Policy.String())
+		}
+		if len(got.Tags) != test.wantTags {
+			t.Errorf("Parse(%s) tags want=%v got=%v", test.in, test.wantTags, len(got.Tags))
+		}
+	}
+}
+
+func TestReasonString(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want string
+	}{
+		{"razón", "razón"},
+		{"raz[score]10[/score]ón", "[score]10[/score]razón"},
+		{"[score]0[/score]razón", "razón"},
+		{"raz[policy]b=2,a=1[/policy]ón[score]-1[/score]", "[score]-1[/score][policy]a=1,b=2[/policy]razón"},
+		{"[SRC]list1[/src]razón", "[SRC]list1[/src]razón"},
+		{"ra[x]z[/x]ón[score]1[/score]", "[score]1[/score]ra[x]z[/x]ón"},
+		{"razón[sig]k1:abc[/sig]", "[sig]k1:abc[/sig]razón"},
+		// removing tags joins a new tag
+		{"[sco[policy]x=1[/policy]re]1[/score]", "[score]1[/score][policy]x=1[/policy]"},
+	}
+	for _, test := range tests {
+		r, err := reason.Parse(test.in)
+		if err != nil {
+			t.Fatalf("Parse(%s) unexpected err: %v", test.in, err)
+		}
+		got := r.String()
+		if got != test.want {
+			t.Errorf("Parse(%s).String() want=%v got=%v", test.in, test.want, got)
+		}
+		// round trip must be lossless
+		r2, err := reason.Parse(got)
+		if err != nil {
+			t.Fatalf("Parse(%s) unexpected err: %v", got, err)
+		}
+		if r2.String() != got {
+			t.Errorf("Parse(%s).String() want=%v got=%v", got, got, r2.String())
+		}
+	}
+}
+
+func TestReasonEncodeErr(t *testing.T) {
+	r := reason.Reason{
+		Text: "razón",
+		Tags: []reason.Tag{{Name: "sig", Value: "x[/sig][score]90[/score]"}},
+	}
+	if _, err := r.Encode(); err == nil {
+		t.Errorf("Encode() expected err")
+	}
+}
@@ -0,0 +1,592 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RuleSet is a list of rules evaluated against parsed reasons. It must be
+// constructed using ParseRules.
+//
+// Each rule has the syntax:
+//
+//	[if] condition then assignment[, assignment...]
+//
+// Rules are separated by new lines or ';' and '#' starts a comment.
+// Conditions compare operands using =, ==, !=, <, <=, >, >=, contains and
+// matches (regular expression) and can be combined with and (&&), or (||),
+// not (!) and parentheses. Operands are score, text, policy.<field>, numbers,
+// quoted strings and bare words. A policy field alone checks its existence,
+// comparisons with a missing policy field are false except !=, that is true.
+// Assignments set the action (action=value) or a policy field
+// (policy.<field>=value).
+type RuleSet struct {
+	rules []rule
+}
+
+// Decision is the result of evaluating a rule set.
+type Decision struct {
+	// Rule is the index of the rule matched, -1 if no rule matched
+	Rule int
+	// Action set by the rule matched
+	Action string
+	// Policy updated by the rule matched
+	Policy Policy
+}
+
+// SyntaxError is returned by ParseRules.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Offset int
+	Msg    string
+}
+
+// Error implements error interface.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %v:%v: %s", e.Line, e.Column, e.Msg)
+}
+
+// ParseRules parses a rule set.
+func ParseRules(src string) (*RuleSet, error) {
+	p := &ruleParser{src: src}
+	err := p.lex()
+	if err != nil {
+		return nil, err
+	}
+	rs := &RuleSet{}
+	for {
+		for p.peek().kind == tokSep {
+			p.next()
+		}
+		if p.peek().kind == tokEOF {
+			break
+		}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		rs.rules = append(rs.rules, r)
+	}
+	return rs, nil
+}
+
+// Len returns the number of rules.
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Eval evaluates the rules in order against the reason. The first rule
+// matched sets the decision. The policy of the reason is not modified.
+func (rs *RuleSet) Eval(r Reason) (Decision, error) {
+	d := Decision{Rule: -1, Policy: NewPolicy()}
+	d.Policy.Merge(r.Policy)
+	for i, rule := range rs.rules {
+		match, err := rule.cond.eval(r)
+		if err != nil {
+			return d, fmt.Errorf("rule %v: %v", i, err)
+		}
+		if !match {
+			continue
+		}
+		d.Rule = i
+		for _, a := range rule.assigns {
+			if a.field == "" {
+				d.Action = a.value
+				continue
+			}
+			err = d.Policy.Set(a.field, a.value)
+			if err != nil {
+				return d, fmt.Errorf("rule %v: setting '%s': %v", i, a.field, err)
+			}
+		}
+		return d, nil
+	}
+	return d, nil
+}
+
+// EvalString parses the reason string and evaluates the rules.
+func (rs *RuleSet) EvalString(s string) (Decision, error) {
+	r, err := Parse(s)
+	if err != nil {
+		return Decision{Rule: -1, Policy: NewPolicy()}, err
+	}
+	return rs.Eval(r)
+}
+
+type rule struct {
+	cond    condNode
+	assigns []assign
+}
+
+// assign sets a policy field, or the action if field is empty.
+type assign struct {
+	field string
+	value string
+}
+
+// condNode is a node of a condition.
+type condNode interface {
+	eval(r Reason) (bool, error)
+}
+
+type andNode struct{ l, r condNode }
+
+func (n andNode) eval(r Reason) (bool, error) {
+	v, err := n.l.eval(r)
+	if err != nil || !v {
+		return false, err
+	}
+	return n.r.eval(r)
+}
+
+type orNode struct{ l, r condNode }
+
+func (n orNode) eval(r Reason) (bool, error) {
+	v, err := n.l.eval(r)
+	if err != nil || v {
+		return v, err
+	}
+	return n.r.eval(r)
+}
+
+type notNode struct{ n condNode }
+
+func (n notNode) eval(r Reason) (bool, error) {
+	v, err := n.n.eval(r)
+	return !v, err
+}
+
+type constNode bool
+
+func (n constNode) eval(r Reason) (bool, error) {
+	return bool(n), nil
+}
+
+// existsNode checks an operand alone: policy fields must exist, score must
+// be non zero and text non empty.
+type existsNode struct{ o operand }
+
+func (n existsNode) eval(r Reason) (bool, error) {
+	v, ok := n.o.value(r)
+	switch {
+	case n.o.kind == operandPolicy:
+		return ok, nil
+	case n.o.kind == operandScore:
+		return r.Score != 0, nil
+	default:
+		return v != "", nil
+	}
+}
+
+type cmpNode struct {
+	op   string
+	l, r operand
+	re   *regexp.Regexp
+}
+
+func (n cmpNode) eval(r Reason) (bool, error) {
+	lv, lok := n.l.value(r)
+	rv, rok := n.r.value(r)
+	if !lok || !rok {
+		// missing policy field
+		return n.op == "!=", nil
+	}
+	switch n.op {
+	case "contains":
+		return strings.Contains(lv, rv), nil
+	case "matches":
+		if n.re != nil {
+			return n.re.MatchString(lv), nil
+		}
+		re, err := regexp.Compile(rv)
+		if err != nil {
+			return false, fmt.Errorf("invalid regexp '%s'", rv)
+		}
+		return re.MatchString(lv), nil
+	}
+	cmp := strings.Compare(lv, rv)
+	ln, lerr := strconv.ParseFloat(lv, 64)
+	rn, rerr := strconv.ParseFloat(rv, 64)
+	if lerr == nil && rerr == nil {
+		switch {
+		case ln < rn:
+			cmp = -1
+		case ln > rn:
+			cmp = 1
+		default:
+			cmp = 0
+		}
+	}
+	switch n.op {
+	case "=", "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return false, fmt.Errorf("invalid operator '%s'", n.op)
+}
+
+// operand kinds
+const (
+	operandLiteral = iota
+	operandScore
+	operandText
+	operandPolicy
+)
+
+type operand struct {
+	kind int
+	text string // literal value or policy field
+}
+
+func (o operand) value(r Reason) (string, bool) {
+	switch o.kind {
+	case operandScore:
+		return strconv.Itoa(r.Score), true
+	case operandText:
+		return r.Text, true
+	case operandPolicy:
+		return r.Policy.Get(o.text)
+	}
+	return o.text, true
+}
+
+// token kinds
+const (
+	tokEOF = iota
+	tokSep
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+type ruleParser struct {
+	src    string
+	tokens []token
+	cur    int
+}
+
+func (p *ruleParser) errorf(pos int, format string, args ...interface{}) error {
+	line, col := 1, 1
+	for _, c := range p.src[:pos] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &SyntaxError{Line: line, Column: col, Offset: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *ruleParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '#':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '\n' || c == ';':
+			p.tokens = append(p.tokens, token{kind: tokSep, text: string(c), pos: i})
+			i++
+		case isSpace(c):
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{kind: tokLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{kind: tokRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			p.tokens = append(p.tokens, token{kind: tokComma, text: ",", pos: i})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return p.errorf(i, "unterminated string")
+			}
+			text, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return p.errorf(i, "invalid string %s", s[i:j+1])
+			}
+			p.tokens = append(p.tokens, token{kind: tokString, text: text, pos: i})
+			i = j + 1
+		case strings.IndexByte("=!<>&|", c) >= 0:
+			j := i + 1
+			if j < len(s) && strings.IndexByte("=&|", s[j]) >= 0 {
+				j++
+			}
+			op := s[i:j]
+			switch op {
+			case "=", "==", "!=", "<", "<=", ">", ">=":
+			case "!":
+				op = "not"
+			case "&&":
+				op = "and"
+			case "||":
+				op = "or"
+			default:
+				return p.errorf(i, "invalid operator '%s'", op)
+			}
+			p.tokens = append(p.tokens, token{kind: tokOp, text: op, pos: i})
+			i = j
+		case isWordChar(c):
+			j := i
+			for j < len(s) && isWordChar(s[j]) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokWord, text: s[i:j], pos: i})
+			i = j
+		default:
+			return p.errorf(i, "unexpected character '%c'", c)
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokEOF, pos: len(s)})
+	return nil
+}
+
+func isWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '_' || c == '.' || c == '-' || c == ':' || c == '@' || c == '/'
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.cur]
+}
+
+func (p *ruleParser) next() token {
+	t := p.tokens[p.cur]
+	if t.kind != tokEOF {
+		p.cur++
+	}
+	return t
+}
+
+// isKeyword returns true if token is the keyword (case insensitive).
+func (t token) isKeyword(k string) bool {
+	return (t.kind == tokWord || t.kind == tokOp) && strings.EqualFold(t.text, k)
+}
+
+func (p *ruleParser) parseRule() (rule, error) {
+	var r rule
+	if p.peek().isKeyword("if") {
+		p.next()
+	}
+	cond, err := p.parseOr()
+	if err != nil {
+		return r, err
+	}
+	r.cond = cond
+	if t := p.next(); !t.isKeyword("then") {
+		return r, p.errorf(t.pos, "expected 'then', found %s", t.describe())
+	}
+	for {
+		a, err := p.parseAssign()
+		if err != nil {
+			return r, err
+		}
+		r.assigns = append(r.assigns, a)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	if t := p.peek(); t.kind != tokSep && t.kind != tokEOF {
+		return r, p.errorf(t.pos, "expected end of rule, found %s", t.describe())
+	}
+	return r, nil
+}
+
+func (p *ruleParser) parseAssign() (assign, error) {
+	var a assign
+	t := p.next()
+	if t.kind != tokWord {
+		return a, p.errorf(t.pos, "expected assignment, found %s", t.describe())
+	}
+	switch {
+	case strings.EqualFold(t.text, "action"):
+	case strings.HasPrefix(strings.ToLower(t.text), "policy."):
+		a.field = t.text[len("policy."):]
+		if !isField(a.field) {
+			return a, p.errorf(t.pos, "invalid policy field '%s'", a.field)
+		}
+	default:
+		return a, p.errorf(t.pos, "cannot assign '%s'", t.text)
+	}
+	if op := p.next(); op.kind != tokOp || (op.text != "=" && op.text != "==") {
+		return a, p.errorf(op.pos, "expected '=', found %s", op.describe())
+	}
+	v := p.next()
+	if v.kind != tokWord && v.kind != tokString {
+		return a, p.errorf(v.pos, "expected value, found %s", v.describe())
+	}
+	a.value = v.text
+	return a, nil
+}
+
+func (p *ruleParser) parseOr() (condNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = orNode{l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *ruleParser) parseAnd() (condNode, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.next()
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = andNode{l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *ruleParser) parseNot() (condNode, error) {
+	if p.peek().isKeyword("not") {
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *ruleParser) parsePrimary() (condNode, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokLParen:
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokRParen {
+			return nil, p.errorf(t.pos, "expected ')', found %s", t.describe())
+		}
+		return n, nil
+	case t.isKeyword("true"):
+		p.next()
+		return constNode(true), nil
+	case t.isKeyword("false"):
+		p.next()
+		return constNode(false), nil
+	}
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek()
+	isCmp := op.kind == tokOp && op.text != "not" && op.text != "and" && op.text != "or"
+	if !isCmp && !op.isKeyword("contains") && !op.isKeyword("matches") {
+		if l.kind == operandLiteral {
+			return nil, p.errorf(t.pos, "expected condition, found %s", t.describe())
+		}
+		return existsNode{o: l}, nil
+	}
+	p.next()
+	n := cmpNode{op: strings.ToLower(op.text), l: l}
+	rt := p.peek()
+	n.r, err = p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "matches" && n.r.kind == operandLiteral {
+		n.re, err = regexp.Compile(n.r.text)
+		if err != nil {
+			return nil, p.errorf(rt.pos, "invalid regexp '%s'", n.r.text)
+		}
+	}
+	return n, nil
+}
+
+func (p *ruleParser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return operand{kind: operandLiteral, text: t.text}, nil
+	case tokWord:
+		lower := strings.ToLower(t.text)
+		switch {
+		case isReservedWord(lower):
+			return operand{}, p.errorf(t.pos, "unexpected %s", t.describe())
+		case lower == "score":
+			return operand{kind: operandScore}, nil
+		case lower == "text":
+			return operand{kind: operandText}, nil
+		case strings.HasPrefix(lower, "policy."):
+			field := t.text[len("policy."):]
+			if !isField(field) {
+				return operand{}, p.errorf(t.pos, "invalid policy field '%s'", field)
+			}
+			return operand{kind: operandPolicy, text: field}, nil
+		}
+		return operand{kind: operandLiteral, text: t.text}, nil
+	}
+	return operand{}, p.errorf(t.pos, "expected operand, found %s", t.describe())
+}
+
+func isReservedWord(s string) bool {
+	switch s {
+	case "if", "then", "and", "or", "not", "contains", "matches", "true", "false":
+		return true
+	}
+	return false
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of input"
+	case tokSep:
+		return "end of rule"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
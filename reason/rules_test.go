@@ -0,0 +1,137 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestRulesPrecedence(t *testing.T) {
+	var tests = []struct {
+		cond string
+		want bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"false and false or true", true},
+		{"false and (false or true)", false},
+		{"not false and false", false},
+		{"not (false and false)", true},
+		{"not not true", true},
+		{"! false && true", true},
+		{"false || !true || true", true},
+		{"score >= 50 and policy.dns=block", true},
+		{"score > 60 or policy.dns = block and text contains phishing", false},
+		{"(score > 60 or policy.dns = block) and text contains malware", true},
+		{"score < 60 and not policy.log", true},
+		{"policy.dns", true},
+		{"policy.ttl >= 9", true},
+		{"policy.ttl > 10", false},
+		{"score = 55.0", true},
+		{`text matches "^mal.*list$"`, true},
+		{`text = "malware list"`, true},
+		{"text != other", true},
+		// missing policy fields
+		{"policy.log < 10", false},
+		{"policy.log = \"\"", false},
+		{"policy.log != 10", true},
+		{"policy.log contains x or score > policy.log", false},
+	}
+	r, err := reason.Parse("[score]55[/score][policy]dns=block,ttl=10[/policy]malware list")
+	if err != nil {
+		t.Fatalf("Parse unexpected err: %v", err)
+	}
+	for _, test := range tests {
+		rs, err := reason.ParseRules(test.cond + " then action=match")
+		if err != nil {
+			t.Errorf("ParseRules(%s) unexpected err: %v", test.cond, err)
+			continue
+		}
+		d, err := rs.Eval(r)
+		if err != nil {
+			t.Errorf("Eval(%s) unexpected err: %v", test.cond, err)
+			continue
+		}
+		if got := d.Action == "match"; got != test.want {
+			t.Errorf("Eval(%s) want=%v got=%v", test.cond, test.want, got)
+		}
+	}
+}
+
+func TestRulesEval(t *testing.T) {
+	rs, err := reason.ParseRules(`
+# drop blocked domains with high score
+if score >= 50 and policy.dns=block then action=drop, policy.event=high
+if score >= 50 then action=log; if policy.dns=block then action=drop
+policy.ttl < 10 then action=cache
+true then action=accept
+`)
+	if err != nil {
+		t.Fatalf("ParseRules unexpected err: %v", err)
+	}
+	if rs.Len() != 5 {
+		t.Fatalf("ParseRules want 5 rules got %v", rs.Len())
+	}
+	var tests = []struct {
+		in         string
+		wantRule   int
+		wantAction string
+		wantPolicy string
+	}{
+		{"[score]60[/score][policy]dns=block[/policy]razón", 0, "drop", "[policy]dns=block,event=high[/policy]"},
+		{"[score]60[/score]razón", 1, "log", ""},
+		{"[policy]dns=block[/policy]razón", 2, "drop", "[policy]dns=block[/policy]"},
+		{"[policy]ttl=5[/policy]razón", 3, "cache", "[policy]ttl=5[/policy]"},
+		{"razón", 4, "accept", ""},
+	}
+	for _, test := range tests {
+		d, err := rs.EvalString(test.in)
+		if err != nil {
+			t.Errorf("EvalString(%s) unexpected err: %v", test.in, err)
+			continue
+		}
+		if d.Rule != test.wantRule || d.Action != test.wantAction || d.Policy.String() != test.wantPolicy {
+			t.Errorf("EvalString(%s) = %v, %v, %v", test.in, d.Rule, d.Action, d.Policy.String())
+		}
+	}
+	// policy of the reason must not be modified
+	r, _ := reason.Parse("[score]60[/score][policy]dns=block[/policy]razón")
+	rs.Eval(r)
+	if _, ok := r.Policy.Get("event"); ok {
+		t.Error("Eval modified reason policy")
+	}
+}
+
+func TestRulesSyntaxError(t *testing.T) {
+	var tests = []struct {
+		in         string
+		wantLine   int
+		wantColumn int
+	}{
+		{"score >= 50 action=drop", 1, 13},
+		{"score >= then action=drop", 1, 10},
+		{"(score >= 50 then action=drop", 1, 14},
+		{"score >= 50 then score=1", 1, 18},
+		{"true then action=drop\nscore $ 3 then action=drop", 2, 7},
+		{"true then action=drop\n\ttext matches \"(\" then action=drop", 2, 15},
+		{"true then action=drop extra", 1, 23},
+		{"true then action=\"drop", 1, 18},
+		{"blocked then action=drop", 1, 1},
+		{"true then policy.1x=drop", 1, 11},
+	}
+	for _, test := range tests {
+		_, err := reason.ParseRules(test.in)
+		serr, ok := err.(*reason.SyntaxError)
+		if !ok {
+			t.Errorf("ParseRules(%s) expected syntax error, got %v", test.in, err)
+			continue
+		}
+		if serr.Line != test.wantLine || serr.Column != test.wantColumn {
+			t.Errorf("ParseRules(%s) want=%v:%v got=%v", test.in, test.wantLine, test.wantColumn, serr)
+		}
+	}
+}
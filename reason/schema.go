@@ -0,0 +1,120 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// FieldType defines the type of the values of a policy field.
+type FieldType string
+
+// Field types supported by schemas.
+const (
+	TypeString   FieldType = "string"
+	TypeInt      FieldType = "int"
+	TypeBool     FieldType = "bool"
+	TypeDuration FieldType = "duration"
+	TypeList     FieldType = "list"
+)
+
+// FieldSpec defines a field of a policy schema.
+type FieldSpec struct {
+	// Type of the field, if empty string is used
+	Type FieldType `json:"type,omitempty"`
+	// Values allowed, if empty any value of the type is allowed. In list
+	// fields, it applies to each item
+	Values []string `json:"values,omitempty"`
+}
+
+// Schema defines the fields of a policy understood by a service.
+type Schema struct {
+	// Fields allowed in policies
+	Fields map[string]FieldSpec `json:"fields"`
+	// Strict if unknown fields must be rejected, otherwise they are
+	// returned as warnings
+	Strict bool `json:"strict,omitempty"`
+}
+
+// Validate checks the policy. Invalid values are always errors, unknown
+// fields are errors only if schema is strict, otherwise they are returned
+// as warnings.
+func (s Schema) Validate(p Policy) (warnings []string, err error) {
+	fields := p.Fields()
+	sort.Strings(fields)
+	for _, field := range fields {
+		spec, ok := s.Fields[field]
+		if !ok {
+			if s.Strict {
+				return warnings, fmt.Errorf("unknown field '%s'", field)
+			}
+			warnings = append(warnings, fmt.Sprintf("unknown field '%s'", field))
+			continue
+		}
+		value, _ := p.Get(field)
+		err = spec.check(value)
+		if err != nil {
+			return warnings, fmt.Errorf("field '%s': %v", field, err)
+		}
+	}
+	return warnings, nil
+}
+
+func (spec FieldSpec) check(value string) error {
+	var err error
+	switch spec.Type {
+	case "", TypeString:
+	case TypeInt:
+		_, err = strconv.Atoi(value)
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeDuration:
+		_, err = time.ParseDuration(value)
+	case TypeList:
+		for _, item := range splitList(value) {
+			if !spec.allowed(item) {
+				return fmt.Errorf("value '%s' not allowed", item)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported type '%s'", spec.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s '%s'", spec.Type, value)
+	}
+	if !spec.allowed(value) {
+		return fmt.Errorf("value '%s' not allowed", value)
+	}
+	return nil
+}
+
+func (spec FieldSpec) allowed(value string) bool {
+	if len(spec.Values) == 0 {
+		return true
+	}
+	for _, v := range spec.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractPolicyWithSchema extracts a policy from a reason string and
+// validates it using the schema. It returns the policy, an string reason
+// without the policy, the warnings of the validation and error.
+func ExtractPolicyWithSchema(s string, schema Schema) (Policy, string, []string, error) {
+	p, reason, err := ExtractPolicy(s)
+	if err != nil {
+		return p, reason, nil, err
+	}
+	warnings, err := schema.Validate(p)
+	if err != nil {
+		return p, reason, warnings, fmt.Errorf("invalid policy: %v", err)
+	}
+	return p, reason, warnings, nil
+}
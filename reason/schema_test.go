@@ -0,0 +1,68 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+var testSchema = reason.Schema{
+	Fields: map[string]reason.FieldSpec{
+		"dns":   {Type: reason.TypeString, Values: []string{"nxdomain", "checkip", "return"}},
+		"ttl":   {Type: reason.TypeInt},
+		"log":   {Type: reason.TypeBool},
+		"cache": {Type: reason.TypeDuration},
+		"lists": {Type: reason.TypeList, Values: []string{"l1", "l2"}},
+		"event": {},
+	},
+}
+
+func TestSchemaValidate(t *testing.T) {
+	var tests = []struct {
+		in           string
+		strict       bool
+		wantErr      bool
+		wantWarnings int
+	}{
+		{"[policy]dns=nxdomain,ttl=60,log=true,cache=1m,lists=\"l1,l2\",event=any[/policy]", false, false, 0},
+		{"[policy]dns=nxdomain,unknown=1[/policy]", false, false, 1},
+		{"[policy]dns=nxdomain,unknown=1[/policy]", true, true, 0},
+		{"[policy]dns=drop[/policy]", false, true, 0},
+		{"[policy]ttl=sixty[/policy]", false, true, 0},
+		{"[policy]log=maybe[/policy]", false, true, 0},
+		{"[policy]cache=1[/policy]", false, true, 0},
+		{"[policy]lists=\"l1,l3\"[/policy]", false, true, 0},
+	}
+	for _, test := range tests {
+		schema := testSchema
+		schema.Strict = test.strict
+		_, _, warnings, err := reason.ExtractPolicyWithSchema(test.in, schema)
+		if err != nil && !test.wantErr {
+			t.Errorf("ExtractPolicyWithSchema(%s) unexpected err: %v", test.in, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("ExtractPolicyWithSchema(%s) expected err", test.in)
+		}
+		if len(warnings) != test.wantWarnings {
+			t.Errorf("ExtractPolicyWithSchema(%s) warnings want=%v got=%v", test.in, test.wantWarnings, warnings)
+		}
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	data := []byte(`{"fields": {"dns": {"type": "string", "values": ["nxdomain"]}, "ttl": {"type": "int"}}, "strict": true}`)
+	var schema reason.Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("unmarshal schema: %v", err)
+	}
+	p, _, _ := reason.ExtractPolicy("[policy]dns=nxdomain,ttl=60[/policy]")
+	if _, err := schema.Validate(p); err != nil {
+		t.Errorf("Validate unexpected err: %v", err)
+	}
+	p, _, _ = reason.ExtractPolicy("[policy]dns=nxdomain,log=true[/policy]")
+	if _, err := schema.Validate(p); err == nil {
+		t.Errorf("Validate expected err")
+	}
+}
@@ -4,25 +4,51 @@ package reason
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
 
-// scoreFromString loads from string the value
-func scoreFromString(s string) (int, error) {
-	slower := strings.ToLower(s)
-	if !strings.HasPrefix(slower, "[score]") || !strings.HasSuffix(slower, "[/score]") {
-		return 0, fmt.Errorf("invalid score '%s'", s)
+const scoreTag = "score"
+
+// Score stores a score and, optionally, the source that assigned it.
+// Scores with source are encoded as [score]value@source[/score].
+//
+// Sources are not backward compatible on the wire: parsers prior to sources
+// fail with these scores, so they must only be encoded when all the hops
+// that parse the reason have been upgraded. Scores without source are
+// encoded as before.
+type Score struct {
+	Value  int    `json:"value"`
+	Source string `json:"source,omitempty"`
+}
+
+// String returns the score encoded as string.
+func (s Score) String() string {
+	if s.Source == "" {
+		return scoreToString(s.Value)
 	}
-	s = s[7 : len(slower)-8] //len('[score]')==7, len('[/score]')==8
+	return fmt.Sprintf("[score]%v@%s[/score]", s.Value, s.Source)
+}
+
+// scoreFromValue loads the value from the content of a score tag
+func scoreFromValue(s string) (Score, error) {
 	if s == "" {
-		return 0, nil
+		return Score{}, nil
+	}
+	var source string
+	if idx := strings.IndexByte(s, '@'); idx >= 0 {
+		source = s[idx+1:]
+		if !isSource(source) {
+			return Score{}, fmt.Errorf("invalid score source '%s'", source)
+		}
+		s = s[:idx]
 	}
 	value, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, fmt.Errorf("invalid score '%s'", s)
+		return Score{}, fmt.Errorf("invalid score '%s'", s)
 	}
-	return value, nil
+	return Score{Value: value, Source: source}, nil
 }
 
 // scoreToString returns the score string
@@ -33,72 +59,178 @@ func scoreToString(score int) string {
 // WithScore inserts a score inside a reason string. If there is a score
 // inside, WithScore will replace it.
 func WithScore(score int, s string) string {
-	s = cleanScore(s)
+	_, s = scanTags(s, scoreTag)
 	if score == 0 {
 		return s
 	}
 	return fmt.Sprintf("%s%s", scoreToString(score), s)
 }
 
+// AddScore inserts a score assigned by source inside a reason string. If
+// there is a score from the same source, AddScore will replace it. Scores
+// from other sources are kept.
+func AddScore(score int, source string, s string) (string, error) {
+	if !isSource(source) {
+		return s, fmt.Errorf("invalid score source '%s'", source)
+	}
+	var b strings.Builder
+	b.WriteString(Score{Value: score, Source: source}.String())
+	scores, rest := scanTags(s, scoreTag)
+	for _, block := range scores {
+		sc, err := scoreFromValue(block.value)
+		if err == nil && sc.Source == source {
+			continue
+		}
+		b.WriteString(tagToString(scoreTag, block.value))
+	}
+	b.WriteString(rest)
+	return b.String(), nil
+}
+
 // ExtractScore extracts a score from a reason string. It returns the score,
 // an string reason without the score and error
 func ExtractScore(s string) (int, string, error) {
+	return ExtractScoreWith(s, ScoreAggregation{})
+}
+
+// ExtractScoreWith extracts the scores from a reason string and aggregates
+// them. It returns the score, an string reason without the scores and error.
+func ExtractScoreWith(s string, agg ScoreAggregation) (int, string, error) {
+	scores, reason, err := ExtractScores(s)
+	if err != nil {
+		return 0, reason, err
+	}
+	value, err := agg.Aggregate(scores)
+	return value, reason, err
+}
+
+// ExtractScores extracts all scores from a reason string with their sources.
+// It returns the scores, an string reason without the scores and error.
+func ExtractScores(s string) ([]Score, string, error) {
+	blocks, reason := scanTags(s, scoreTag)
+	scores := make([]Score, 0, len(blocks))
+	for _, block := range blocks {
+		sc, err := scoreFromValue(block.value)
+		if err != nil {
+			return scores, reason, fmt.Errorf("invalid score '%s': %v", tagToString(scoreTag, block.value), err)
+		}
+		scores = append(scores, sc)
+	}
+	return scores, reason, nil
+}
+
+// ScoreMode defines how scores are aggregated.
+type ScoreMode string
+
+// Aggregation modes.
+const (
+	ScoreSum      ScoreMode = "sum"
+	ScoreMax      ScoreMode = "max"
+	ScoreMin      ScoreMode = "min"
+	ScoreAverage  ScoreMode = "avg"
+	ScoreWeighted ScoreMode = "weighted"
+)
+
+// ScoreAggregation defines the aggregation of the scores found in a reason
+// string. Zero value sums all scores.
+type ScoreAggregation struct {
+	// Mode of aggregation, if empty sum is used
+	Mode ScoreMode `json:"mode,omitempty"`
+	// Weights by source used by weighted mode, it sums the scores multiplied
+	// by the weight of their source
+	Weights map[string]float64 `json:"weights,omitempty"`
+	// DefaultWeight is used for sources without weight, if zero 1 is used
+	DefaultWeight float64 `json:"defaultweight,omitempty"`
+	// Clamp result to range [Lower, Upper]
+	Clamp bool `json:"clamp,omitempty"`
+	Lower int  `json:"lower,omitempty"`
+	Upper int  `json:"upper,omitempty"`
+}
+
+// Validate if aggregation is ok.
+func (a ScoreAggregation) Validate() error {
+	switch a.Mode {
+	case "", ScoreSum, ScoreMax, ScoreMin, ScoreAverage, ScoreWeighted:
+	default:
+		return fmt.Errorf("invalid score mode '%s'", a.Mode)
+	}
+	if a.Clamp && a.Lower > a.Upper {
+		return fmt.Errorf("clamp lower %v is greater than upper %v", a.Lower, a.Upper)
+	}
+	return nil
+}
+
+// Aggregate returns the result of aggregating the scores. If there are no
+// scores, it returns 0 (clamped if required).
+func (a ScoreAggregation) Aggregate(scores []Score) (int, error) {
 	value := 0
-	scores, reason := extractScoreStr(s)
 	if len(scores) > 0 {
-		for _, scoreStr := range scores {
-			v, err := scoreFromString(scoreStr)
-			if err != nil {
-				return value, reason, fmt.Errorf("invalid score '%s': %v", scoreStr, err)
+		switch a.Mode {
+		case "", ScoreSum:
+			for _, s := range scores {
+				value = value + s.Value
+			}
+		case ScoreMax:
+			value = scores[0].Value
+			for _, s := range scores[1:] {
+				if s.Value > value {
+					value = s.Value
+				}
+			}
+		case ScoreMin:
+			value = scores[0].Value
+			for _, s := range scores[1:] {
+				if s.Value < value {
+					value = s.Value
+				}
+			}
+		case ScoreAverage:
+			sum := 0
+			for _, s := range scores {
+				sum = sum + s.Value
+			}
+			value = int(math.Round(float64(sum) / float64(len(scores))))
+		case ScoreWeighted:
+			sum := 0.0
+			for _, s := range scores {
+				sum = sum + float64(s.Value)*a.weight(s.Source)
 			}
-			value = value + v
+			value = int(math.Round(sum))
+		default:
+			return 0, fmt.Errorf("invalid score mode '%s'", a.Mode)
 		}
 	}
-	return value, reason, nil
-}
-
-func cleanScore(s string) (rest string) {
-	rest = ""
-	for len(s) > 0 {
-		slow := strings.ToLower(s)
-		first := strings.Index(slow, "[score]")
-		last := strings.Index(slow, "[/score]")
-		if first < 0 || last < 0 {
-			rest = rest + s
-			return
+	if a.Clamp {
+		if value < a.Lower {
+			value = a.Lower
 		}
-		if last < first {
-			//len("[/score]") == 8
-			rest = rest + s[:last+8]
-			s = s[last+8:]
-			continue
+		if value > a.Upper {
+			value = a.Upper
 		}
-		rest = rest + s[:first]
-		s = s[last+8:]
 	}
-	return
+	return value, nil
 }
 
-func extractScoreStr(s string) (scores []string, rest string) {
-	rest = ""
-	scores = make([]string, 0)
-	for len(s) > 0 {
-		slow := strings.ToLower(s)
-		first := strings.Index(slow, "[score]")
-		last := strings.Index(slow, "[/score]")
-		if first < 0 || last < 0 {
-			rest = rest + s
-			return
-		}
-		if last < first {
-			//len("[/score]") == 8
-			rest = rest + s[:last+8]
-			s = s[last+8:]
-			continue
+func (a ScoreAggregation) weight(source string) float64 {
+	if w, ok := a.Weights[source]; ok {
+		return w
+	}
+	if a.DefaultWeight != 0 {
+		return a.DefaultWeight
+	}
+	return 1
+}
+
+// isSource returns true if s is a valid score source.
+func isSource(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isLetter(c) && !isDigit(c) && strings.IndexByte("_.:/-", c) < 0 {
+			return false
 		}
-		scores = append(scores, s[first:last+8])
-		rest = rest + s[:first]
-		s = s[last+8:]
 	}
-	return
+	return true
 }
@@ -110,3 +110,102 @@ func TestExtractScore(t *testing.T) {
 		}
 	}
 }
+
+func TestExtractScores(t *testing.T) {
+	var tests = []struct {
+		in         string
+		wantErr    bool
+		want       []reason.Score
+		wantReason string
+	}{
+		{"razón", false, []reason.Score{}, "razón"},
+		{"[score]10@l1[/score]raz[score]-5[/score]ón", false, []reason.Score{{10, "l1"}, {-5, ""}}, "razón"},
+		{"[score]10@list.example.com[/score]razón", false, []reason.Score{{10, "list.example.com"}}, "razón"},
+		{"[score]10@[/score]razón", true, nil, "razón"},
+		{"[score]@l1[/score]razón", true, nil, "razón"},
+		{"[score]10@l 1[/score]razón", true, nil, "razón"},
+	}
+	for _, test := range tests {
+		got, reason, err := reason.ExtractScores(test.in)
+		if err != nil && !test.wantErr {
+			t.Errorf("ExtractScores(%s) unexpected err: %v", test.in, err)
+			continue
+		} else if err == nil && test.wantErr {
+			t.Errorf("ExtractScores(%s) expected err", test.in)
+			continue
+		}
+		if reason != test.wantReason {
+			t.Errorf("ExtractScores(%s) want=%v got=%v", test.in, test.wantReason, reason)
+		}
+		if test.wantErr {
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Fatalf("ExtractScores(%s) want=%v got=%v", test.in, test.want, got)
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("ExtractScores(%s) want=%v got=%v", test.in, test.want, got)
+			}
+		}
+	}
+}
+
+func TestExtractScoreWith(t *testing.T) {
+	in := "[score]10@l1[/score][score]40@l2[/score]razón[score]-20@l3[/score]"
+	var tests = []struct {
+		agg     reason.ScoreAggregation
+		wantErr bool
+		want    int
+	}{
+		{reason.ScoreAggregation{}, false, 30},
+		{reason.ScoreAggregation{Mode: reason.ScoreSum}, false, 30},
+		{reason.ScoreAggregation{Mode: reason.ScoreMax}, false, 40},
+		{reason.ScoreAggregation{Mode: reason.ScoreMin}, false, -20},
+		{reason.ScoreAggregation{Mode: reason.ScoreAverage}, false, 10},
+		{reason.ScoreAggregation{Mode: reason.ScoreWeighted, Weights: map[string]float64{"l1": 2, "l3": 0.5}}, false, 50},
+		{reason.ScoreAggregation{Mode: reason.ScoreWeighted, DefaultWeight: 0.5}, false, 15},
+		{reason.ScoreAggregation{Mode: reason.ScoreSum, Clamp: true, Lower: 0, Upper: 20}, false, 20},
+		{reason.ScoreAggregation{Mode: reason.ScoreMin, Clamp: true, Lower: 0, Upper: 20}, false, 0},
+		{reason.ScoreAggregation{Mode: "median"}, true, 0},
+	}
+	for _, test := range tests {
+		got, reason, err := reason.ExtractScoreWith(in, test.agg)
+		if err != nil && !test.wantErr {
+			t.Errorf("ExtractScoreWith(%v) unexpected err: %v", test.agg, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("ExtractScoreWith(%v) expected err", test.agg)
+		}
+		if got != test.want {
+			t.Errorf("ExtractScoreWith(%v) want=%v got=%v", test.agg, test.want, got)
+		}
+		if reason != "razón" {
+			t.Errorf("ExtractScoreWith(%v) unexpected reason: %v", test.agg, reason)
+		}
+	}
+}
+
+func TestAddScore(t *testing.T) {
+	s, err := reason.AddScore(10, "l1", "[score]5[/score]razón")
+	if err != nil {
+		t.Fatalf("AddScore unexpected err: %v", err)
+	}
+	s, _ = reason.AddScore(20, "l2", s)
+	s, _ = reason.AddScore(30, "l1", s)
+	if want := "[score]30@l1[/score][score]20@l2[/score][score]5[/score]razón"; s != want {
+		t.Errorf("AddScore want=%v got=%v", want, s)
+	}
+	if got, _, _ := reason.ExtractScore(s); got != 55 {
+		t.Errorf("ExtractScore(%s) want=55 got=%v", s, got)
+	}
+	r, err := reason.Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%s) unexpected err: %v", s, err)
+	}
+	if r.String() != s {
+		t.Errorf("Parse(%s).String() got=%v", s, r.String())
+	}
+	if _, err := reason.AddScore(10, "l[1]", s); err == nil {
+		t.Error("AddScore expected err")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Level defines a named severity level and the minimum score to reach it.
+type Level struct {
+	Name string `json:"name"`
+	Min  int    `json:"min"`
+}
+
+// Classifier maps scores to severity levels. Levels must be ordered by
+// their minimum score.
+type Classifier struct {
+	// Levels ordered from lowest to highest severity
+	Levels []Level `json:"levels"`
+	// Aggregation used when scores are extracted from reason strings
+	Aggregation ScoreAggregation `json:"aggregation,omitempty"`
+}
+
+// DefaultClassifier is the classifier used when there is no configuration.
+var DefaultClassifier = Classifier{
+	Levels: []Level{
+		{Name: "info", Min: 0},
+		{Name: "low", Min: 10},
+		{Name: "medium", Min: 30},
+		{Name: "high", Min: 60},
+		{Name: "critical", Min: 90},
+	},
+}
+
+// LoadClassifier loads a classifier from a json document and validates it.
+// Classifiers can also be loaded from other formats, like yaml, using viper:
+// field names match the keys ignoring case, so v.UnmarshalKey(key, &c) can be
+// used followed by c.Validate().
+func LoadClassifier(r io.Reader) (Classifier, error) {
+	var c Classifier
+	err := json.NewDecoder(r).Decode(&c)
+	if err != nil {
+		return c, fmt.Errorf("reason: decoding classifier: %v", err)
+	}
+	err = c.Validate()
+	if err != nil {
+		return c, fmt.Errorf("reason: invalid classifier: %v", err)
+	}
+	return c, nil
+}
+
+// Validate if classifier is ok.
+func (c Classifier) Validate() error {
+	if len(c.Levels) == 0 {
+		return errors.New("levels are required")
+	}
+	names := make(map[string]bool, len(c.Levels))
+	for i, l := range c.Levels {
+		if l.Name == "" {
+			return errors.New("level name can't be empty")
+		}
+		if names[l.Name] {
+			return fmt.Errorf("level '%s' is duplicated", l.Name)
+		}
+		names[l.Name] = true
+		if i > 0 && l.Min <= c.Levels[i-1].Min {
+			return fmt.Errorf("level '%s' must have a greater min than '%s'", l.Name, c.Levels[i-1].Name)
+		}
+	}
+	err := c.Aggregation.Validate()
+	if err != nil {
+		return fmt.Errorf("aggregation: %v", err)
+	}
+	return nil
+}
+
+// Classify returns the name of the level for the score: the level with the
+// greatest minimum lower or equal than score. Scores below all levels are
+// classified in the first level.
+func (c Classifier) Classify(score int) string {
+	if len(c.Levels) == 0 {
+		return ""
+	}
+	for i := len(c.Levels) - 1; i > 0; i-- {
+		if score >= c.Levels[i].Min {
+			return c.Levels[i].Name
+		}
+	}
+	return c.Levels[0].Name
+}
+
+// ExtractLevel extracts the scores from a reason string using the
+// aggregation of the classifier. It returns the level, the score, an string
+// reason without the scores and error.
+func (c Classifier) ExtractLevel(s string) (string, int, string, error) {
+	score, reason, err := ExtractScoreWith(s, c.Aggregation)
+	if err != nil {
+		return "", score, reason, err
+	}
+	return c.Classify(score), score, reason, nil
+}
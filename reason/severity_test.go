@@ -0,0 +1,87 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestClassify(t *testing.T) {
+	var tests = []struct {
+		in   int
+		want string
+	}{
+		{-10, "info"},
+		{0, "info"},
+		{9, "info"},
+		{10, "low"},
+		{59, "medium"},
+		{60, "high"},
+		{1000, "critical"},
+	}
+	for _, test := range tests {
+		if got := reason.DefaultClassifier.Classify(test.in); got != test.want {
+			t.Errorf("Classify(%v) want=%v got=%v", test.in, test.want, got)
+		}
+	}
+}
+
+func TestLoadClassifier(t *testing.T) {
+	var tests = []struct {
+		in      string
+		wantErr bool
+	}{
+		{`{"levels": [{"name": "ok", "min": 0}, {"name": "bad", "min": 50}]}`, false},
+		{`{"levels": [{"name": "ok", "min": 0}], "aggregation": {"mode": "max"}}`, false},
+		{`{"levels": []}`, true},
+		{`{"levels": [{"name": "ok", "min": 50}, {"name": "bad", "min": 50}]}`, true},
+		{`{"levels": [{"name": "ok", "min": 0}, {"name": "ok", "min": 50}]}`, true},
+		{`{"levels": [{"min": 0}]}`, true},
+		{`{"levels": `, true},
+		{`{"levels": [{"name": "ok", "min": 0}], "aggregation": {"mode": "median"}}`, true},
+		{`{"levels": [{"name": "ok", "min": 0}], "aggregation": {"clamp": true, "lower": 10, "upper": 0}}`, true},
+		{`{"levels": [{"name": "ok", "min": 0}], "aggregation": {"clamp": true, "lower": 0, "upper": 100}}`, false},
+	}
+	for _, test := range tests {
+		_, err := reason.LoadClassifier(strings.NewReader(test.in))
+		if err != nil && !test.wantErr {
+			t.Errorf("LoadClassifier(%s) unexpected err: %v", test.in, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("LoadClassifier(%s) expected err", test.in)
+		}
+	}
+}
+
+func TestExtractLevel(t *testing.T) {
+	c, err := reason.LoadClassifier(strings.NewReader(
+		`{"levels": [{"name": "ok", "min": 0}, {"name": "bad", "min": 50}], "aggregation": {"mode": "max"}}`))
+	if err != nil {
+		t.Fatalf("LoadClassifier unexpected err: %v", err)
+	}
+	var tests = []struct {
+		in         string
+		wantErr    bool
+		want       string
+		wantScore  int
+		wantReason string
+	}{
+		{"razón", false, "ok", 0, "razón"},
+		{"[score]30@l1[/score][score]40@l2[/score]razón", false, "ok", 40, "razón"},
+		{"[score]30@l1[/score][score]60@l2[/score]razón", false, "bad", 60, "razón"},
+		{"[score]x[/score]razón", true, "", 0, "razón"},
+	}
+	for _, test := range tests {
+		got, score, reason, err := c.ExtractLevel(test.in)
+		if err != nil && !test.wantErr {
+			t.Errorf("ExtractLevel(%s) unexpected err: %v", test.in, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("ExtractLevel(%s) expected err", test.in)
+		}
+		if got != test.want || score != test.wantScore || reason != test.wantReason {
+			t.Errorf("ExtractLevel(%s) = %v, %v, %v", test.in, got, score, reason)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const sigTag = "sig"
+
+// Errors returned when verifying signatures.
+var (
+	ErrNotSigned    = errors.New("reason: not signed")
+	ErrUnknownKey   = errors.New("reason: unknown signing key")
+	ErrBadSignature = errors.New("reason: invalid signature")
+)
+
+// KeyRing stores the keys used to sign and verify reason strings. Reasons are
+// signed with the current key and verified with any key in the ring, so keys
+// can be rotated adding a new key, using it and removing the old key when
+// all signers have been updated. It's safe for concurrent use.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewKeyRing returns a new empty key ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte)}
+}
+
+// Add adds a key to the ring. If it's the first key, it will be used for
+// signing.
+func (k *KeyRing) Add(id string, key []byte) error {
+	if !isKeyID(id) {
+		return fmt.Errorf("reason: invalid key id '%s'", id)
+	}
+	if len(key) == 0 {
+		return errors.New("reason: key can't be empty")
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("reason: key '%s' already exists", id)
+	}
+	k.keys[id] = append([]byte(nil), key...)
+	if k.current == "" {
+		k.current = id
+	}
+	return nil
+}
+
+// Remove removes a key from the ring. If it's the current key, there will be
+// no key for signing until Use is called.
+func (k *KeyRing) Remove(id string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, id)
+	if k.current == id {
+		k.current = ""
+	}
+}
+
+// Use sets the key used for signing.
+func (k *KeyRing) Use(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("reason: key '%s' doesn't exist", id)
+	}
+	k.current = id
+	return nil
+}
+
+// Current returns the id of the key used for signing.
+func (k *KeyRing) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *KeyRing) get(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Sign signs the scores and the policy of the reason string with the current
+// key of the ring. It returns the reason string with a [sig]id:mac[/sig] tag,
+// replacing previous signatures. Text and other tags are not signed.
+func Sign(s string, ring *KeyRing) (string, error) {
+	blocks, _ := scanTags(s, knownTags()...)
+	s = removeBlocks(s, blocks, sigTag)
+	id := ring.Current()
+	key, ok := ring.get(id)
+	if !ok {
+		return s, errors.New("reason: there is no key for signing")
+	}
+	r, err := Parse(s)
+	if err != nil {
+		return s, err
+	}
+	sig := id + ":" + base64.RawURLEncoding.EncodeToString(mac(key, signedPayload(r)))
+	return tagToString(sigTag, sig) + s, nil
+}
+
+// Verify checks the signature of the reason string and returns the id of the
+// key used. It returns ErrNotSigned, ErrUnknownKey or ErrBadSignature if
+// signature can't be verified.
+func Verify(s string, ring *KeyRing) (string, error) {
+	id, _, err := verify(s, ring)
+	return id, err
+}
+
+// ParseVerified verifies the signature of the reason string and then parses
+// it. It returns the reason and the id of the key used.
+func ParseVerified(s string, ring *KeyRing) (Reason, string, error) {
+	id, r, err := verify(s, ring)
+	if err != nil {
+		return Reason{Policy: NewPolicy()}, id, err
+	}
+	return r, id, nil
+}
+
+// ExtractVerifiedPolicy extracts the policy from a signed reason string. If
+// signature can't be verified, it returns an empty policy and error. It
+// returns the policy, the reason without the policy and the signature in its
+// canonical encoding, and error.
+func ExtractVerifiedPolicy(s string, ring *KeyRing) (Policy, string, error) {
+	_, r, err := verify(s, ring)
+	policy := r.Policy
+	r.Policy = NewPolicy()
+	tags := r.Tags[:0:0]
+	for _, t := range r.Tags {
+		if t.Name != sigTag {
+			tags = append(tags, t)
+		}
+	}
+	r.Tags = tags
+	if err != nil {
+		return NewPolicy(), r.String(), err
+	}
+	return policy, r.String(), nil
+}
+
+// verify parses the reason string and checks its signature. The signed
+// payload and the reason returned are built from the same parse, so signed
+// tags can't be hidden inside other tags. It returns the id of the key and
+// the reason, that is returned even if signature can't be verified.
+func verify(s string, ring *KeyRing) (string, Reason, error) {
+	r, err := Parse(s)
+	if err != nil {
+		return "", r, err
+	}
+	var sigs []string
+	for _, t := range r.Tags {
+		if t.Name == sigTag {
+			sigs = append(sigs, t.Value)
+		}
+	}
+	if len(sigs) == 0 {
+		return "", r, ErrNotSigned
+	}
+	if len(sigs) > 1 {
+		return "", r, ErrBadSignature
+	}
+	idx := strings.IndexByte(sigs[0], ':')
+	if idx < 0 {
+		return "", r, ErrBadSignature
+	}
+	id := sigs[0][:idx]
+	got, err := base64.RawURLEncoding.DecodeString(sigs[0][idx+1:])
+	if err != nil {
+		return id, r, ErrBadSignature
+	}
+	key, ok := ring.get(id)
+	if !ok {
+		return id, r, ErrUnknownKey
+	}
+	if !hmac.Equal(got, mac(key, signedPayload(r))) {
+		return id, r, ErrBadSignature
+	}
+	return id, r, nil
+}
+
+// signedPayload returns the canonical encoding of the scores, sorted, and
+// the merged policy of the reason.
+func signedPayload(r Reason) string {
+	encoded := make([]string, 0, len(r.Scores))
+	for _, sc := range r.Scores {
+		encoded = append(encoded, sc.String())
+	}
+	sort.Strings(encoded)
+	var b strings.Builder
+	for _, e := range encoded {
+		b.WriteString(e)
+	}
+	if !r.Policy.Empty() {
+		b.WriteString(r.Policy.String())
+	}
+	return b.String()
+}
+
+// removeBlocks returns the string without the blocks of the tags passed.
+func removeBlocks(s string, blocks []tagBlock, names ...string) string {
+	var b strings.Builder
+	last := 0
+	for _, block := range blocks {
+		if acceptTag(block.name, names) {
+			b.WriteString(s[last:block.offset])
+			last = block.end
+		}
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func mac(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// isKeyID returns true if s is a valid key id.
+func isKeyID(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isLetter(c) && !isDigit(c) && strings.IndexByte("_.-", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestSignVerify(t *testing.T) {
+	ring := reason.NewKeyRing()
+	if err := ring.Add("k1", []byte("secret1")); err != nil {
+		t.Fatalf("Add unexpected err: %v", err)
+	}
+	signed, err := reason.Sign("[score]10@l1[/score][policy]action=allow[/policy]razón", ring)
+	if err != nil {
+		t.Fatalf("Sign unexpected err: %v", err)
+	}
+	if !strings.HasPrefix(signed, "[sig]k1:") {
+		t.Errorf("Sign unexpected result: %v", signed)
+	}
+	var tests = []struct {
+		in      string
+		wantErr error
+	}{
+		{signed, nil},
+		// text and tag order are not signed
+		{strings.Replace(signed, "razón", "otra razón", 1), nil},
+		{"[policy]action=allow[/policy]" + strings.Replace(signed, "[policy]action=allow[/policy]", "", 1), nil},
+		{signed + "[sig]k1:abc[/sig]", reason.ErrBadSignature},
+		{strings.Replace(signed, "action=allow", "action=block", 1), reason.ErrBadSignature},
+		{strings.Replace(signed, "10@l1", "90@l1", 1), reason.ErrBadSignature},
+		{signed + "[score]5[/score]", reason.ErrBadSignature},
+		{signed + "[policy]ttl=60[/policy]", reason.ErrBadSignature},
+		{strings.Replace(signed, "k1:", "k2:", 1), reason.ErrUnknownKey},
+		{strings.Replace(signed, "k1:", "k1:$", 1), reason.ErrBadSignature},
+		{"[policy]action=allow[/policy]razón", reason.ErrNotSigned},
+	}
+	for _, test := range tests {
+		id, err := reason.Verify(test.in, ring)
+		if err != test.wantErr {
+			t.Errorf("Verify(%s) want err=%v got=%v", test.in, test.wantErr, err)
+		}
+		if err == nil && id != "k1" {
+			t.Errorf("Verify(%s) want=k1 got=%v", test.in, id)
+		}
+	}
+	// clean removes signatures
+	if got := reason.Clean(signed); got != "razón" {
+		t.Errorf("Clean(%s) want=razón got=%v", signed, got)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ring := reason.NewKeyRing()
+	ring.Add("k1", []byte("secret1"))
+	old, _ := reason.Sign("[policy]action=allow[/policy]razón", ring)
+	if err := ring.Add("k1", []byte("other")); err == nil {
+		t.Error("Add expected err with duplicated key")
+	}
+	if err := ring.Add("k 2", []byte("secret2")); err == nil {
+		t.Error("Add expected err with invalid id")
+	}
+	if err := ring.Use("k2"); err == nil {
+		t.Error("Use expected err with unknown key")
+	}
+	ring.Add("k2", []byte("secret2"))
+	if err := ring.Use("k2"); err != nil {
+		t.Fatalf("Use unexpected err: %v", err)
+	}
+	// resign replaces signature
+	signed, _ := reason.Sign(old, ring)
+	if !strings.HasPrefix(signed, "[sig]k2:") || strings.Count(signed, "[sig]") != 1 {
+		t.Errorf("Sign unexpected result: %v", signed)
+	}
+	for _, s := range []string{old, signed} {
+		if _, err := reason.Verify(s, ring); err != nil {
+			t.Errorf("Verify(%s) unexpected err: %v", s, err)
+		}
+	}
+	ring.Remove("k1")
+	if _, err := reason.Verify(old, ring); err != reason.ErrUnknownKey {
+		t.Errorf("Verify(%s) want err=%v got=%v", old, reason.ErrUnknownKey, err)
+	}
+	ring.Remove("k2")
+	if _, err := reason.Sign(old, ring); err == nil {
+		t.Error("Sign expected err without current key")
+	}
+}
+
+func TestExtractVerifiedPolicy(t *testing.T) {
+	ring := reason.NewKeyRing()
+	ring.Add("k1", []byte("secret1"))
+	signed, _ := reason.Sign("[policy]action=allow[/policy]razón", ring)
+	p, rest, err := reason.ExtractVerifiedPolicy(signed, ring)
+	if err != nil {
+		t.Fatalf("ExtractVerifiedPolicy unexpected err: %v", err)
+	}
+	if v, _ := p.Get("action"); v != "allow" || rest != "razón" {
+		t.Errorf("ExtractVerifiedPolicy unexpected result: %v %v", p, rest)
+	}
+	tampered := strings.Replace(signed, "allow", "block", 1)
+	p, rest, err = reason.ExtractVerifiedPolicy(tampered, ring)
+	if err != reason.ErrBadSignature || !p.Empty() || rest != "razón" {
+		t.Errorf("ExtractVerifiedPolicy unexpected result: %v %v %v", p, rest, err)
+	}
+	r, id, err := reason.ParseVerified(signed, ring)
+	if err != nil || id != "k1" || r.Text != "razón" {
+		t.Errorf("ParseVerified unexpected result: %v %v %v", r, id, err)
+	}
+	if _, _, err := reason.ParseVerified(tampered, ring); err == nil {
+		t.Error("ParseVerified expected err")
+	}
+}
+
+func TestSignHiddenTags(t *testing.T) {
+	ring := reason.NewKeyRing()
+	ring.Add("k1", []byte("secret1"))
+	signed, _ := reason.Sign("[score]100[/score][policy]action=block[/policy]bad", ring)
+	// signed tags inside other tags can't be hidden
+	in := "[x]" + signed + "[/x]"
+	r, _, err := reason.ParseVerified(in, ring)
+	if err != nil {
+		t.Fatalf("ParseVerified(%s) unexpected err: %v", in, err)
+	}
+	if v, _ := r.Policy.Get("action"); r.Score != 100 || v != "block" {
+		t.Errorf("ParseVerified(%s) unexpected result: %v %v", in, r.Score, r.Policy)
+	}
+	p, _, err := reason.ExtractVerifiedPolicy(in, ring)
+	if v, _ := p.Get("action"); err != nil || v != "block" {
+		t.Errorf("ExtractVerifiedPolicy(%s) unexpected result: %v %v", in, p, err)
+	}
+	// a registered tag hides the signature
+	in = "[lists]" + signed + "[/lists]"
+	if _, _, err := reason.ParseVerified(in, ring); err != reason.ErrNotSigned {
+		t.Errorf("ParseVerified(%s) want err=%v got=%v", in, reason.ErrNotSigned, err)
+	}
+	// policies inside registered tags are not extracted
+	in = signed + "[lists]l1[policy]action=allow[/policy][/lists]"
+	p, _, err = reason.ExtractVerifiedPolicy(in, ring)
+	if v, _ := p.Get("action"); err != nil || v != "block" {
+		t.Errorf("ExtractVerifiedPolicy(%s) unexpected result: %v %v", in, p, err)
+	}
+}
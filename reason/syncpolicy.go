@@ -0,0 +1,94 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// SyncPolicy is a policy safe for concurrent use. Values are stored in an
+// immutable map that is replaced by a modified copy on each write
+// (copy-on-write), so reads never block and snapshots can be cached and
+// shared between goroutines. The zero value is an empty policy ready to use.
+type SyncPolicy struct {
+	mu sync.Mutex // serializes writers
+	v  atomic.Value
+}
+
+// NewSyncPolicy returns a new concurrent safe policy with a copy of the
+// values of the policy passed.
+func NewSyncPolicy(p Policy) *SyncPolicy {
+	sp := &SyncPolicy{}
+	sp.v.Store(p.Clone().m)
+	return sp
+}
+
+// load returns the current values, nil maps are handled as empty
+func (sp *SyncPolicy) load() map[string]string {
+	m, _ := sp.v.Load().(map[string]string)
+	return m
+}
+
+// Empty returns true if the policy is empty.
+func (sp *SyncPolicy) Empty() bool {
+	return len(sp.load()) == 0
+}
+
+// Get returns the value of the field.
+func (sp *SyncPolicy) Get(field string) (string, bool) {
+	value, ok := sp.load()[field]
+	return value, ok
+}
+
+// Fields returns the fields in the policy.
+func (sp *SyncPolicy) Fields() []string {
+	return Policy{m: sp.load()}.Fields()
+}
+
+// String returns the policy encoded as string.
+func (sp *SyncPolicy) String() string {
+	return Policy{m: sp.load()}.String()
+}
+
+// Snapshot returns a copy of the current values as a Policy.
+func (sp *SyncPolicy) Snapshot() Policy {
+	return Policy{m: sp.load()}.Clone()
+}
+
+// Clone returns a new concurrent safe policy with the current values.
+func (sp *SyncPolicy) Clone() *SyncPolicy {
+	c := &SyncPolicy{}
+	// the map is never modified, so it can be shared
+	c.v.Store(sp.load())
+	return c
+}
+
+// Set a new field policy or modify existing value.
+func (sp *SyncPolicy) Set(field, value string) error {
+	if !isField(field) {
+		return errors.New("invalid field")
+	}
+	sp.update(func(p Policy) { p.m[field] = value })
+	return nil
+}
+
+// Delete removes the field from the policy.
+func (sp *SyncPolicy) Delete(field string) {
+	sp.update(func(p Policy) { delete(p.m, field) })
+}
+
+// Merge policies.
+func (sp *SyncPolicy) Merge(policies ...Policy) {
+	sp.update(func(p Policy) { p.Merge(policies...) })
+}
+
+// update applies fn to a copy of the values and stores the result.
+func (sp *SyncPolicy) update(fn func(p Policy)) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	p := Policy{m: sp.load()}.Clone()
+	fn(p)
+	sp.v.Store(p.m)
+}
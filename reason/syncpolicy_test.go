@@ -0,0 +1,102 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+func TestPolicyClone(t *testing.T) {
+	p := reason.NewPolicy()
+	p.Set("dns", "nxdomain")
+	c := p.Clone()
+	c.Set("dns", "checkip")
+	c.Set("log", "true")
+	if v, _ := p.Get("dns"); v != "nxdomain" {
+		t.Errorf("Clone modified original: %v", p.String())
+	}
+	if _, ok := p.Get("log"); ok {
+		t.Errorf("Clone modified original: %v", p.String())
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	p := reason.NewPolicy()
+	p.Set("dns", "nxdomain")
+	sp := reason.NewSyncPolicy(p)
+	// changes in original don't affect
+	p.Set("dns", "checkip")
+	if v, _ := sp.Get("dns"); v != "nxdomain" {
+		t.Errorf("Get(dns) want=nxdomain got=%v", v)
+	}
+	snap := sp.Snapshot()
+	clone := sp.Clone()
+	sp.Set("log", "true")
+	if _, ok := snap.Get("log"); ok {
+		t.Error("Set modified snapshot")
+	}
+	if _, ok := clone.Get("log"); ok {
+		t.Error("Set modified clone")
+	}
+	sp.Delete("dns")
+	if got, want := sp.String(), "[policy]log=true[/policy]"; got != want {
+		t.Errorf("String() want=%v got=%v", want, got)
+	}
+	if err := sp.Set("1bad", "x"); err == nil {
+		t.Error("Set expected err")
+	}
+}
+
+func TestSyncPolicyZero(t *testing.T) {
+	var sp reason.SyncPolicy
+	if !sp.Empty() {
+		t.Error("zero value must be empty")
+	}
+	if _, ok := sp.Get("dns"); ok {
+		t.Error("Get(dns) found in zero value")
+	}
+	if got := sp.String(); got != "" {
+		t.Errorf("String() want='' got=%v", got)
+	}
+	sp.Set("dns", "nxdomain")
+	if v, _ := sp.Get("dns"); v != "nxdomain" {
+		t.Errorf("Get(dns) want=nxdomain got=%v", v)
+	}
+}
+
+func TestSyncPolicyConcurrent(t *testing.T) {
+	sp := reason.NewSyncPolicy(reason.NewPolicy())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sp.Set(fmt.Sprintf("field%d", i), fmt.Sprintf("%d", j))
+				p := reason.NewPolicy()
+				p.Set("common", fmt.Sprintf("%d", i))
+				sp.Merge(p)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				sp.Get("common")
+				_ = sp.String()
+				snap := sp.Snapshot()
+				snap.Set("local", "x")
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(sp.Fields()); got != 11 {
+		t.Errorf("Fields() want=11 got=%v", got)
+	}
+	if _, ok := sp.Get("local"); ok {
+		t.Error("snapshot modified shared policy")
+	}
+}
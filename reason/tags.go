@@ -0,0 +1,187 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// TagCodec defines how the value of a custom tag is encoded, decoded and
+// merged. Encode and Decode are required.
+type TagCodec struct {
+	// Encode returns the value encoded as string
+	Encode func(v interface{}) (string, error)
+	// Decode returns the value of an encoded string
+	Decode func(s string) (interface{}, error)
+	// Merge returns the result of merging two values when the tag is found
+	// more than once. If it's nil, the last value wins
+	Merge func(a, b interface{}) (interface{}, error)
+}
+
+var tagRegistry = struct {
+	mu     sync.RWMutex
+	codecs map[string]TagCodec
+	known  []string // built-in and registered names, replaced on register
+}{
+	codecs: make(map[string]TagCodec),
+	known:  []string{policyTag, scoreTag, sigTag},
+}
+
+// RegisterTag registers a codec for the custom tag name. Once registered,
+// tag will be decoded by Parse and removed by Clean. Names are case
+// insensitive and score, policy and sig are reserved.
+func RegisterTag(name string, codec TagCodec) error {
+	name = strings.ToLower(name)
+	if !tagRegExp.MatchString(name) {
+		return fmt.Errorf("reason: invalid tag name '%s'", name)
+	}
+	if name == scoreTag || name == policyTag || name == sigTag {
+		return fmt.Errorf("reason: tag name '%s' is reserved", name)
+	}
+	if codec.Encode == nil || codec.Decode == nil {
+		return errors.New("reason: encode and decode functions are required")
+	}
+	tagRegistry.mu.Lock()
+	defer tagRegistry.mu.Unlock()
+	if _, ok := tagRegistry.codecs[name]; ok {
+		return fmt.Errorf("reason: tag '%s' already registered", name)
+	}
+	tagRegistry.codecs[name] = codec
+	known := make([]string, len(tagRegistry.known), len(tagRegistry.known)+1)
+	copy(known, tagRegistry.known)
+	tagRegistry.known = append(known, name)
+	return nil
+}
+
+// RegisteredTags returns the names of the custom tags registered.
+func RegisteredTags() []string {
+	tagRegistry.mu.RLock()
+	defer tagRegistry.mu.RUnlock()
+	names := make([]string, 0, len(tagRegistry.codecs))
+	for name := range tagRegistry.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithTag inserts a custom tag inside a reason string. If there is the same
+// tag inside, WithTag will replace it. If value is nil, the tag is removed.
+func WithTag(name string, value interface{}, s string) (string, error) {
+	name = strings.ToLower(name)
+	codec, ok := getCodec(name)
+	if !ok {
+		return s, fmt.Errorf("reason: tag '%s' not registered", name)
+	}
+	_, s = scanTags(s, name)
+	if value == nil {
+		return s, nil
+	}
+	encoded, err := encodeTag(codec, name, value)
+	if err != nil {
+		return s, err
+	}
+	return fmt.Sprintf("%s%s", tagToString(name, encoded), s), nil
+}
+
+// ExtractTag extracts a custom tag from a reason string. It returns the
+// value (nil if the tag doesn't exist), an string reason without the tag and
+// error.
+func ExtractTag(name, s string) (interface{}, string, error) {
+	name = strings.ToLower(name)
+	codec, ok := getCodec(name)
+	if !ok {
+		return nil, s, fmt.Errorf("reason: tag '%s' not registered", name)
+	}
+	blocks, reason := scanTags(s, name)
+	var value interface{}
+	for _, b := range blocks {
+		v, err := decodeTag(codec, b, value)
+		if err != nil {
+			return nil, reason, err
+		}
+		value = v
+	}
+	return value, reason, nil
+}
+
+// decodeTag decodes the block and merges it with the previous value.
+func decodeTag(codec TagCodec, b tagBlock, prev interface{}) (interface{}, error) {
+	v, err := codec.Decode(b.value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s '%s': %v", b.name, tagToString(b.name, b.value), err)
+	}
+	if prev == nil || codec.Merge == nil {
+		return v, nil
+	}
+	v, err = codec.Merge(prev, v)
+	if err != nil {
+		return nil, fmt.Errorf("merging %s '%s': %v", b.name, tagToString(b.name, b.value), err)
+	}
+	return v, nil
+}
+
+// encodeTags returns the custom values encoded, sorted by tag name.
+func encodeTags(custom map[string]interface{}) (string, error) {
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		codec, ok := getCodec(name)
+		if !ok {
+			return "", fmt.Errorf("reason: tag '%s' not registered", name)
+		}
+		encoded, err := encodeTag(codec, name, custom[name])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(tagToString(name, encoded))
+	}
+	return b.String(), nil
+}
+
+// encodeTag encodes the value checking that the result can be decoded.
+func encodeTag(codec TagCodec, name string, value interface{}) (string, error) {
+	encoded, err := codec.Encode(value)
+	if err != nil {
+		return "", fmt.Errorf("reason: encoding tag '%s': %v", name, err)
+	}
+	if err := checkTagValue(name, encoded); err != nil {
+		return "", err
+	}
+	return encoded, nil
+}
+
+// checkTagValue returns an error if the value contains its own close tag,
+// because it would end the tag block when parsing.
+func checkTagValue(name, value string) error {
+	if cstart, _ := closeTag(value, 0, name); cstart >= 0 {
+		return fmt.Errorf("reason: encoding tag '%s': value contains close tag", name)
+	}
+	return nil
+}
+
+func getCodec(name string) (TagCodec, bool) {
+	tagRegistry.mu.RLock()
+	defer tagRegistry.mu.RUnlock()
+	codec, ok := tagRegistry.codecs[name]
+	return codec, ok
+}
+
+// knownTags returns built-in and registered tag names. The slice returned
+// must not be modified.
+func knownTags() []string {
+	tagRegistry.mu.RLock()
+	defer tagRegistry.mu.RUnlock()
+	return tagRegistry.known
+}
+
+var tagRegExp, _ = regexp.Compile(`^[a-z][a-z0-9_]*$`)
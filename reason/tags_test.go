@@ -0,0 +1,169 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. See LICENSE.
+
+package reason_test
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/luids-io/core/reason"
+)
+
+// listsCodec stores the names of the lists as a slice of strings, merging
+// appends the lists
+var listsCodec = reason.TagCodec{
+	Encode: func(v interface{}) (string, error) {
+		lists, ok := v.([]string)
+		if !ok {
+			return "", errors.New("invalid type")
+		}
+		return strings.Join(lists, ","), nil
+	},
+	Decode: func(s string) (interface{}, error) {
+		return strings.Split(s, ","), nil
+	},
+	Merge: func(a, b interface{}) (interface{}, error) {
+		return append(a.([]string), b.([]string)...), nil
+	},
+}
+
+// confidenceCodec stores an integer, merging keeps the minimum value
+var confidenceCodec = reason.TagCodec{
+	Encode: func(v interface{}) (string, error) {
+		i, ok := v.(int)
+		if !ok {
+			return "", errors.New("invalid type")
+		}
+		return strconv.Itoa(i), nil
+	},
+	Decode: func(s string) (interface{}, error) {
+		return strconv.Atoi(s)
+	},
+	Merge: func(a, b interface{}) (interface{}, error) {
+		if b.(int) < a.(int) {
+			return b, nil
+		}
+		return a, nil
+	},
+}
+
+var _ = registerTestTags()
+
+func registerTestTags() error {
+	if err := reason.RegisterTag("lists", listsCodec); err != nil {
+		return err
+	}
+	return reason.RegisterTag("Confidence", confidenceCodec)
+}
+
+// registerRuns is used to register a new tag on each run of the test, as the
+// registry is global
+var registerRuns = 0
+
+func TestRegisterTag(t *testing.T) {
+	registerRuns++
+	category := "category" + strconv.Itoa(registerRuns)
+	var tests = []struct {
+		name    string
+		codec   reason.TagCodec
+		wantErr bool
+	}{
+		{"score", confidenceCodec, true},
+		{"POLICY", confidenceCodec, true},
+		{"lists", listsCodec, true},
+		{"1bad", confidenceCodec, true},
+		{"ba-d", confidenceCodec, true},
+		{"nocodec", reason.TagCodec{}, true},
+		{category, reason.TagCodec{Encode: listsCodec.Encode, Decode: listsCodec.Decode}, false},
+	}
+	for _, test := range tests {
+		err := reason.RegisterTag(test.name, test.codec)
+		if err != nil && !test.wantErr {
+			t.Errorf("RegisterTag(%s) unexpected err: %v", test.name, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("RegisterTag(%s) expected err", test.name)
+		}
+	}
+}
+
+func TestExtractTag(t *testing.T) {
+	var tests = []struct {
+		in         string
+		wantErr    bool
+		want       interface{}
+		wantReason string
+	}{
+		{"razón", false, nil, "razón"},
+		{"raz[confidence]10[/confidence]ón", false, 10, "razón"},
+		{"[CONFIDENCE]10[/confidence]razón[confidence]5[/confidence]", false, 5, "razón"},
+		{"[confidence]diez[/confidence]razón", true, nil, "razón"},
+		{"[/confidence]razón", false, nil, "[/confidence]razón"},
+	}
+	for _, test := range tests {
+		got, reason, err := reason.ExtractTag("confidence", test.in)
+		if err != nil && !test.wantErr {
+			t.Errorf("ExtractTag(%s) unexpected err: %v", test.in, err)
+		} else if err == nil && test.wantErr {
+			t.Errorf("ExtractTag(%s) expected err", test.in)
+		}
+		if got != test.want {
+			t.Errorf("ExtractTag(%s) want=%v got=%v", test.in, test.want, got)
+		}
+		if reason != test.wantReason {
+			t.Errorf("ExtractTag(%s) want=%v got=%v", test.in, test.wantReason, reason)
+		}
+	}
+	if _, _, err := reason.ExtractTag("unknown", "razón"); err == nil {
+		t.Error("ExtractTag(unknown) expected err")
+	}
+}
+
+func TestWithTag(t *testing.T) {
+	got, err := reason.WithTag("lists", []string{"l1", "l2"}, "[lists]l0[/lists]razón")
+	if err != nil {
+		t.Fatalf("WithTag unexpected err: %v", err)
+	}
+	if want := "[lists]l1,l2[/lists]razón"; got != want {
+		t.Errorf("WithTag want=%v got=%v", want, got)
+	}
+	got, err = reason.WithTag("lists", nil, got)
+	if err != nil {
+		t.Fatalf("WithTag unexpected err: %v", err)
+	}
+	if want := "razón"; got != want {
+		t.Errorf("WithTag want=%v got=%v", want, got)
+	}
+	if _, err = reason.WithTag("lists", []string{"l1[/lists]"}, "razón"); err == nil {
+		t.Error("WithTag expected err")
+	}
+	if _, err = reason.WithTag("confidence", "high", "razón"); err == nil {
+		t.Error("WithTag expected err")
+	}
+}
+
+func TestCustomTags(t *testing.T) {
+	in := "[lists]l1[/lists][score]10[/score]ra[confidence]80[/confidence]zón[lists]l2[/lists][ttl]60[/ttl]"
+	if got, want := reason.Clean(in), "razón[ttl]60[/ttl]"; got != want {
+		t.Errorf("Clean(%s) want=%v got=%v", in, want, got)
+	}
+	r, err := reason.Parse(in)
+	if err != nil {
+		t.Fatalf("Parse(%s) unexpected err: %v", in, err)
+	}
+	lists, ok := r.Custom["lists"].([]string)
+	if !ok || strings.Join(lists, ",") != "l1,l2" {
+		t.Errorf("Parse(%s) unexpected lists: %v", in, r.Custom["lists"])
+	}
+	if r.Custom["confidence"] != 80 {
+		t.Errorf("Parse(%s) unexpected confidence: %v", in, r.Custom["confidence"])
+	}
+	if len(r.Tags) != 0 || r.Text != "razón[ttl]60[/ttl]" {
+		t.Errorf("Parse(%s) unexpected text and tags: %v %v", in, r.Text, r.Tags)
+	}
+	want := "[score]10[/score][confidence]80[/confidence][lists]l1,l2[/lists]razón[ttl]60[/ttl]"
+	if got := r.String(); got != want {
+		t.Errorf("String() want=%v got=%v", want, got)
+	}
+}
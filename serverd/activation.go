@@ -0,0 +1,16 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"os"
+
+	"github.com/luids-io/core/serverd/activation"
+)
+
+// StartChild starts a new instance of the process handing off the listeners
+// returned by activation.Listen and activation.ListenNamed, so services can
+// be restarted without downtime. See activation.StartChild.
+func StartChild() (*os.Process, error) {
+	return activation.StartChild()
+}
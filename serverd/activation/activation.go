@@ -0,0 +1,212 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+// Package activation implements the systemd socket activation protocol to
+// get inherited listeners and to hand them off to a new process, allowing
+// restarts without downtime.
+//
+// This package is a work in progress and makes no API stability promises.
+package activation
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// listener stores a listener and the name used to pass it to other process.
+type listener struct {
+	name string
+	l    net.Listener
+	used bool
+}
+
+// activation stores the inherited listeners and the listeners returned to
+// the process, that will be handed off to children.
+var activation struct {
+	once      sync.Once
+	mu        sync.Mutex
+	inherited []*listener
+	tracked   []*listener
+}
+
+// loadInherited loads the listeners passed using the systemd socket
+// activation protocol (LISTEN_FDS, LISTEN_FDNAMES and LISTEN_PID) and
+// unsets the environment variables, so they are not inherited by children.
+func loadInherited() {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			// not a stream socket
+			continue
+		}
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		activation.inherited = append(activation.inherited, &listener{name: name, l: l})
+	}
+}
+
+// Listen returns a listener on the network address. If the process has
+// inherited a listener with the address, or named as the address, it is
+// returned instead of creating a new one. Listeners returned are handed off
+// to children started with StartChild until they are closed.
+func Listen(network, addr string) (net.Listener, error) {
+	activation.once.Do(loadInherited)
+	activation.mu.Lock()
+	defer activation.mu.Unlock()
+	for _, il := range activation.inherited {
+		if !il.used && (il.name == addr || sameAddr(il.l.Addr(), network, addr)) {
+			il.used = true
+			return track(addr, il.l), nil
+		}
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return track(addr, l), nil
+}
+
+// ListenNamed returns the inherited listener with the name. Listeners
+// returned are handed off to children started with StartChild until they are
+// closed.
+func ListenNamed(name string) (net.Listener, error) {
+	activation.once.Do(loadInherited)
+	activation.mu.Lock()
+	defer activation.mu.Unlock()
+	for _, il := range activation.inherited {
+		if !il.used && il.name == name {
+			il.used = true
+			return track(name, il.l), nil
+		}
+	}
+	return nil, fmt.Errorf("activation: there is no inherited listener named '%s'", name)
+}
+
+// trackedListener is the listener returned to the process, it's untracked
+// when closed, so it isn't handed off.
+type trackedListener struct {
+	net.Listener
+	tl *listener
+}
+
+// Close implements net.Listener.
+func (l *trackedListener) Close() error {
+	activation.mu.Lock()
+	for i, tl := range activation.tracked {
+		if tl == l.tl {
+			activation.tracked = append(activation.tracked[:i], activation.tracked[i+1:]...)
+			break
+		}
+	}
+	activation.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// track adds the listener to the tracked listeners, activation.mu must be
+// held.
+func track(name string, l net.Listener) net.Listener {
+	tl := &listener{name: name, l: l}
+	activation.tracked = append(activation.tracked, tl)
+	return &trackedListener{Listener: l, tl: tl}
+}
+
+// sameAddr returns true if a is the address addr in network.
+func sameAddr(a net.Addr, network, addr string) bool {
+	switch network {
+	case "unix":
+		return a.Network() == "unix" && a.String() == addr
+	case "tcp", "tcp4", "tcp6":
+		ta, ok := a.(*net.TCPAddr)
+		if !ok {
+			return false
+		}
+		tb, err := net.ResolveTCPAddr(network, addr)
+		if err != nil || ta.Port != tb.Port {
+			return false
+		}
+		if len(tb.IP) == 0 || tb.IP.IsUnspecified() {
+			return ta.IP.IsUnspecified()
+		}
+		return ta.IP.Equal(tb.IP)
+	}
+	return false
+}
+
+// StartChild starts a new instance of the executable of the process, with
+// the same arguments, handing off the listeners returned by Listen and
+// ListenNamed using the socket activation protocol. Once the child is ready
+// the parent can be shut down, unix sockets are not removed when closed.
+func StartChild() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("activation: getting executable: %v", err)
+	}
+	activation.mu.Lock()
+	defer activation.mu.Unlock()
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr}
+	names := make([]string, 0, len(activation.tracked))
+	defer func() {
+		for _, f := range files[listenFdsStart:] {
+			f.Close()
+		}
+	}()
+	for _, tl := range activation.tracked {
+		filer, ok := tl.l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("activation: listener '%s' can't be handed off", tl.name)
+		}
+		if ul, ok := tl.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("activation: handing off listener '%s': %v", tl.name, err)
+		}
+		files = append(files, f)
+		names = append(names, strings.Replace(tl.name, ":", "_", -1))
+	}
+	if len(names) == 0 {
+		return nil, errors.New("activation: there are no listeners to hand off")
+	}
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "LISTEN_") {
+			env = append(env, e)
+		}
+	}
+	env = append(env,
+		fmt.Sprintf("LISTEN_FDS=%v", len(names)),
+		fmt.Sprintf("LISTEN_FDNAMES=%s", strings.Join(names, ":")))
+	proc, err := os.StartProcess(exe, os.Args, &os.ProcAttr{Env: env, Files: files})
+	if err != nil {
+		return nil, fmt.Errorf("activation: starting child: %v", err)
+	}
+	return proc, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package activation_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/luids-io/core/serverd/activation"
+)
+
+// TestActivationHelper is executed by TestActivation as child process with
+// inherited listeners.
+func TestActivationHelper(t *testing.T) {
+	if os.Getenv("ACTIVATION_TEST_HELPER") != "1" {
+		t.Skip("helper process")
+	}
+	named, err := activation.ListenNamed("web")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	byAddr, err := activation.Listen("tcp", os.Getenv("ACTIVATION_TEST_ADDR"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		fmt.Println("environment not unset")
+		os.Exit(1)
+	}
+	for _, l := range []net.Listener{named, byAddr} {
+		conn, err := l.Accept()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Fprint(conn, l.Addr().String())
+		conn.Close()
+	}
+	os.Exit(0)
+}
+
+func TestActivation(t *testing.T) {
+	var files []*os.File
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listening: %v", err)
+		}
+		defer l.Close()
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("getting file: %v", err)
+		}
+		defer f.Close()
+		files = append(files, f)
+		addrs = append(addrs, l.Addr().String())
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=TestActivationHelper")
+	cmd.Env = append(os.Environ(),
+		"ACTIVATION_TEST_HELPER=1",
+		"ACTIVATION_TEST_ADDR="+addrs[1],
+		"LISTEN_FDS=2",
+		"LISTEN_FDNAMES=web:other")
+	cmd.ExtraFiles = files
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("getting stdout: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting helper: %v", err)
+	}
+	for _, addr := range addrs {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dialing %s: %v", addr, err)
+		}
+		got, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		if string(got) != addr {
+			t.Errorf("listener want=%s got=%s", addr, got)
+		}
+	}
+	msg, _ := ioutil.ReadAll(out)
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("helper failed: %v: %s", err, msg)
+	}
+}
+
+func TestListenNotInherited(t *testing.T) {
+	l, err := activation.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen unexpected err: %v", err)
+	}
+	l.Close()
+	if _, err := activation.ListenNamed("web"); err == nil {
+		t.Error("ListenNamed expected err")
+	}
+}
+
+func TestStartChildClosed(t *testing.T) {
+	l, err := activation.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen unexpected err: %v", err)
+	}
+	l.Close()
+	// closed listeners are not handed off
+	_, err = activation.StartChild()
+	want := "activation: there are no listeners to hand off"
+	if err == nil || err.Error() != want {
+		t.Errorf("StartChild want err=%v got=%v", want, err)
+	}
+}
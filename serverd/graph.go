@@ -0,0 +1,154 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ParallelStart option starts in parallel the services that don't declare
+// dependencies. By default they are started one after another in
+// registration order and shut down in reverse order.
+func ParallelStart() Option {
+	return func(o *options) {
+		o.parallelStart = true
+	}
+}
+
+// errSkipped is returned by runGraph for the nodes that weren't run.
+var errSkipped = errors.New("skipped")
+
+// graph stores the dependencies between services by index.
+type graph struct {
+	deps       [][]int // services required by each service
+	dependents [][]int // services that require each service
+}
+
+// newGraph returns the graph of dependencies of the services. If ordered is
+// true, services without dependencies depend on the previous service without
+// dependencies, keeping registration order. It returns error if a dependency
+// doesn't exist.
+func newGraph(services []Service, ordered bool) (graph, error) {
+	index := make(map[string]int, len(services))
+	for i, s := range services {
+		index[s.Name] = i
+	}
+	g := graph{
+		deps:       make([][]int, len(services)),
+		dependents: make([][]int, len(services)),
+	}
+	prev := -1 // previous service without dependencies
+	for i, s := range services {
+		if len(s.DependsOn) == 0 && ordered {
+			// implicit dependencies only point backwards to services
+			// without dependencies, so they can't create cycles
+			if prev >= 0 {
+				g.deps[i] = append(g.deps[i], prev)
+				g.dependents[prev] = append(g.dependents[prev], i)
+			}
+			prev = i
+		}
+		for _, name := range s.DependsOn {
+			j, ok := index[name]
+			if !ok {
+				return g, fmt.Errorf("serverd: service %s depends on unknown service %s", s.Name, name)
+			}
+			g.deps[i] = append(g.deps[i], j)
+			g.dependents[j] = append(g.dependents[j], i)
+		}
+	}
+	return g, nil
+}
+
+// checkCycles returns error if there is a dependency cycle between the
+// services. Dependencies on services not registered yet are ignored.
+func checkCycles(services []Service) error {
+	index := make(map[string]int, len(services))
+	for i, s := range services {
+		index[s.Name] = i
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(services))
+	var path []string
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			for j, name := range path {
+				if name == services[i].Name {
+					path = append(path[j:], name)
+					break
+				}
+			}
+			return fmt.Errorf("serverd: dependency cycle %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		path = append(path, services[i].Name)
+		for _, name := range services[i].DependsOn {
+			j, ok := index[name]
+			if !ok {
+				continue
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+	for i := range services {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGraph runs fn for all nodes in parallel, each node waits until fn has
+// finished for the nodes returned by after. If abort is true and fn fails for
+// a node, the nodes that are waiting for it and all the nodes not started yet
+// are skipped. It returns the errors by node.
+func runGraph(after [][]int, abort bool, fn func(i int) error) []error {
+	errs := make([]error, len(after))
+	done := make([]chan struct{}, len(after))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	var failed int32
+	var wg sync.WaitGroup
+	for i := range after {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			for _, j := range after[i] {
+				<-done[j]
+				if abort && errs[j] != nil {
+					errs[i] = errSkipped
+					return
+				}
+			}
+			if abort && atomic.LoadInt32(&failed) > 0 {
+				errs[i] = errSkipped
+				return
+			}
+			errs[i] = fn(i)
+			if errs[i] != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
@@ -7,10 +7,10 @@
 package serverd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/signal"
 	"strings"
 	"sync"
 	"syscall"
@@ -24,12 +24,24 @@ type Option func(*options)
 
 type options struct {
 	logger          yalogi.Logger
+	startTimeout    time.Duration
 	shutdownTimeout time.Duration
+	reloadTimeout   time.Duration
+	pingTimeout     time.Duration
+	parallelStart   bool
+
+	notifySocket     string
+	watchdogInterval time.Duration
+
+	disableSignals  bool
+	shutdownSignals []os.Signal
+	signalFns       map[os.Signal]SignalFn
 }
 
 var defaultOptions = options{
 	logger:          yalogi.LogNull,
 	shutdownTimeout: 2 * time.Second,
+	shutdownSignals: []os.Signal{os.Interrupt, syscall.SIGTERM},
 }
 
 // SetLogger option allows set a custom logger.
@@ -48,21 +60,64 @@ func ShutdownTimeout(d time.Duration) Option {
 	}
 }
 
+// StartTimeout option sets default timeout for services started with
+// context. By default there is no timeout.
+func StartTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.startTimeout = d
+	}
+}
+
+// ReloadTimeout option sets default timeout for services reloaded with
+// context. By default there is no timeout. Reloads are serialized with
+// shutdowns, so a reload that never returns blocks them until the timeout.
+func ReloadTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.reloadTimeout = d
+	}
+}
+
+// PingTimeout option sets default timeout for services pinged with context.
+// By default there is no timeout.
+func PingTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.pingTimeout = d
+	}
+}
+
 // Manager manages the services.
 type Manager struct {
 	opts   options
 	logger yalogi.Logger
 
-	name      string
-	reloadErr error
-	mu        sync.Mutex
-	started   bool
-	services  []Service
+	name     string
+	mu       sync.Mutex
+	started  bool
+	services []Service
+	graph    graph
+	// reload errors and stats by service
+	statmu     sync.Mutex
+	reloadErrs map[string]error
+	stats      map[string]*serviceStats
+	order      []string
+	running    bool
+	force      chan struct{}
+	// supervisors of running services by index
+	supervisors []*supervisor
+	// fatal errors sent by services are forwarded to failed while running
+	fatal   chan error
+	failed  chan error
+	drainCh chan struct{}
+	// systemd notifications
+	notifier   notifier
+	watchdogCh chan struct{}
 }
 
 // New creates a new manager.
 func New(name string, opt ...Option) *Manager {
 	opts := defaultOptions
+	opts.notifySocket = os.Getenv("NOTIFY_SOCKET")
+	opts.watchdogInterval = envWatchdogInterval()
 	for _, o := range opt {
 		o(&opts)
 	}
@@ -71,12 +126,17 @@ func New(name string, opt ...Option) *Manager {
 		logger:   opts.logger,
 		name:     name,
 		services: make([]Service, 0),
+		fatal:    make(chan error, 1),
+		failed:   make(chan error, 1),
+		notifier: notifier{socket: opts.notifySocket},
 	}
 	return m
 }
 
 // Register resgisters a new service in the manager.
-// Services cannot be registered if it has already been started.
+// Services cannot be registered if it has already been started. Services
+// can depend on services not registered yet, but dependency cycles are
+// not allowed.
 func (m *Manager) Register(svc Service) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -91,53 +151,186 @@ func (m *Manager) Register(svc Service) error {
 			return errors.New("serverd: service name can't be duplicated")
 		}
 	}
-	m.services = append(m.services, svc)
+	services := append(m.services[:len(m.services):len(m.services)], svc)
+	err := checkCycles(services)
+	if err != nil {
+		return err
+	}
+	m.services = services
+	m.addStats(svc.Name)
 	return nil
 }
 
-// Start starts managed services in the same order they were registered.
-// If there is an error then it will stop.
+// Start starts managed services. Services are started in parallel, but a
+// service is not started until all its dependencies are started. Services
+// without dependencies are started in registration order unless
+// ParallelStart option is used.
+// If there is an error then it will stop and it will shut down the services
+// already started. The error returned includes the errors found in both
+// steps.
 func (m *Manager) Start() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if m.started {
 		return nil
 	}
+	g, err := newGraph(m.services, !m.opts.parallelStart)
+	if err != nil {
+		return err
+	}
 	m.logger.Infof("starting %s services", m.name)
-	for _, s := range m.services {
+	m.notify(statusMsg("starting"))
+	m.supervisors = make([]*supervisor, len(m.services))
+	m.resetForce()
+	// discard errors of previous runs
+	for _, ch := range []chan error{m.fatal, m.failed} {
+		select {
+		case <-ch:
+		default:
+		}
+	}
+	m.drainCh = make(chan struct{})
+	go m.drainFatal(m.drainCh)
+	startErrs := runGraph(g.deps, true, func(i int) error {
+		s := m.services[i]
 		m.logger.Infof("starting %s", s.Name)
-		err := s.start()
+		m.setState(s.Name, StateStarting, nil)
+		err := s.start(m.opts.startTimeout)
+		if isTimeout(err) {
+			m.logger.Warnf("service %s overran: %v", s.Name, err)
+		}
 		if err != nil {
-			return fmt.Errorf("serverd: starting %s: %v", s.Name, err)
+			m.setState(s.Name, StateFailed, err)
+			return err
+		}
+		var sv *supervisor
+		if s.Run != nil {
+			sv = newSupervisor(s, m.logger, m.fail)
+			sv.start()
+		}
+		m.supervisors[i] = sv
+		m.setSupervisor(s.Name, sv)
+		m.setState(s.Name, StateRunning, nil)
+		return nil
+	})
+	errs := make([]string, 0)
+	for i, err := range startErrs {
+		if err != nil && err != errSkipped {
+			errs = append(errs, fmt.Sprintf("starting %s: %v", m.services[i].Name, err))
 		}
 	}
-	m.started = true
-	return nil
+	if len(errs) == 0 {
+		m.graph = g
+		m.started = true
+		m.setRunning(true)
+		m.notify("READY=1", statusMsg("running"))
+		if m.opts.watchdogInterval > 0 {
+			m.watchdogCh = make(chan struct{})
+			go m.watchdog(m.opts.watchdogInterval, m.watchdogCh)
+		}
+		return nil
+	}
+	// rollback, services that overran could be partially started
+	m.logger.Warnf("starting %s services: %s", m.name, strings.Join(errs, ";"))
+	m.logger.Infof("shutting down started %s services", m.name)
+	shutdownErrs := m.shutdown(g, func(i int) bool {
+		return startErrs[i] == nil || isTimeout(startErrs[i])
+	})
+	for i, err := range shutdownErrs {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("shutting down %s: %v", m.services[i].Name, err))
+		}
+	}
+	m.stopDrain()
+	m.notify(statusMsg("failed: " + strings.Join(errs, ";")))
+	return fmt.Errorf("serverd: %s", strings.Join(errs, ";"))
 }
 
-// Shutdown will stop all services in the opposite order to which they
-// were registered. It will try to execute the "shutdown" function and,
-// if it is turned off in a given time, it will execute the "stop" function
-// of the service.
+// Shutdown will stop all services in parallel, but a service is not
+// stopped until all services that depend on it are stopped. It will try to
+// execute the "shutdown" function and, if it is turned off in a given time,
+// it will execute the "stop" function of the service.
 func (m *Manager) Shutdown() {
+	m.stop()
+}
+
+// stop shuts down all services and returns the errors found.
+func (m *Manager) stop() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if !m.started {
-		return
+		return nil
 	}
 	m.started = false
+	m.setRunning(false)
 	m.logger.Infof("shutting down %s services", m.name)
-	for i := len(m.services) - 1; i >= 0; i-- {
+	m.notify("STOPPING=1", statusMsg("stopping"))
+	if m.watchdogCh != nil {
+		close(m.watchdogCh)
+		m.watchdogCh = nil
+	}
+	errs := make([]string, 0)
+	for i, err := range m.shutdown(m.graph, func(int) bool { return true }) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("shutting down %s: %v", m.services[i].Name, err))
+		}
+	}
+	m.stopDrain()
+	if len(errs) > 0 {
+		return fmt.Errorf("serverd: %s", strings.Join(errs, ";"))
+	}
+	return nil
+}
+
+// shutdown shuts down the selected services and returns the errors by
+// service.
+func (m *Manager) shutdown(g graph, selected func(i int) bool) []error {
+	force := m.forceCh()
+	return runGraph(g.dependents, false, func(i int) error {
+		if !selected(i) {
+			return nil
+		}
 		s := m.services[i]
 		m.logger.Infof("shutting down %s", s.Name)
-		s.shutdown(m.opts.shutdownTimeout)
-	}
+		m.setState(s.Name, StateStopping, nil)
+		var err error
+		if sv := m.supervisors[i]; sv != nil {
+			err = sv.stop(s.timeout(s.ShutdownTimeout, m.opts.shutdownTimeout), force)
+		}
+		if err == nil {
+			err = s.shutdown(m.opts.shutdownTimeout, force)
+		} else if s.Stop != nil {
+			// run function didn't return
+			s.Stop()
+			if terr, ok := err.(*TimeoutError); ok {
+				terr.Stopped = true
+			}
+		}
+		if isTimeout(err) {
+			m.logger.Warnf("service %s overran: %v", s.Name, err)
+		} else if err != nil {
+			m.logger.Warnf("shutting down %s: %v", s.Name, err)
+		}
+		m.setState(s.Name, StateStopped, err)
+		return err
+	})
 }
 
 // Run will initialize all services, install the operating system's
 // signal handlers and block waiting for the shutdown signal.
-// When this signal arrives, it will turn off all registered services.
+// When this signal arrives, it will turn off all registered services, if
+// it arrives again during the shutdown, services will be stopped
+// immediately. If a supervised service with StopManager in its restart
+// policy gives up, or an error is sent to the Fatal channel, it will turn
+// off all services and return the error. Errors found in the shutdown are
+// also returned.
 func (m *Manager) Run() error {
+	return m.RunContext(context.Background())
+}
+
+// RunContext is like Run, but it also turns off all services when the
+// context is cancelled.
+func (m *Manager) RunContext(ctx context.Context) error {
 	// start services
 	err := m.Start()
 	if err != nil {
@@ -145,32 +338,84 @@ func (m *Manager) Run() error {
 	}
 	//launch signal handling goroutine
 	close := make(chan bool, 1)
-	go m.signalHndl(close)
-	//waits for signal
-	<-close
+	done := make(chan struct{}, 1)
+	if !m.opts.disableSignals {
+		go m.signalHndl(close, done)
+	}
+	//waits for signal, context or fatal error
+	select {
+	case <-close:
+	case <-ctx.Done():
+		m.logger.Infof("context done: %v", ctx.Err())
+	case err = <-m.failed:
+		m.logger.Errorf("%v", err)
+	}
 	// shutdown services
-	m.Shutdown()
-	return nil
+	serr := m.stop()
+	done <- struct{}{}
+	switch {
+	case err == nil:
+		return serr
+	case serr == nil:
+		return err
+	}
+	return fmt.Errorf("%v;%s", err, strings.TrimPrefix(serr.Error(), "serverd: "))
+}
+
+// Fatal returns the channel used by services to report a fatal error to
+// the manager. The first error received while running stops the manager
+// and it's returned by Run, the others are discarded. Sends don't block
+// from the start of the services until they are shut down.
+func (m *Manager) Fatal() chan<- error {
+	return m.fatal
 }
 
-func (m *Manager) signalHndl(close chan bool) {
-	sigint := make(chan os.Signal, 1)
-	sigHUP := make(chan os.Signal, 1)
-	signal.Notify(sigint, os.Interrupt, syscall.SIGTERM)
-	signal.Notify(sigHUP, syscall.SIGHUP)
+// fail is called by supervisors to stop the manager, only the first error
+// is kept.
+func (m *Manager) fail(err error) {
+	select {
+	case m.failed <- err:
+	default:
+		m.logger.Debugf("discarding fatal error: %v", err)
+	}
+}
+
+// drainFatal forwards the errors sent to the fatal channel until done is
+// closed.
+func (m *Manager) drainFatal(done chan struct{}) {
 	for {
 		select {
-		case <-sigint:
-			close <- true
+		case err := <-m.fatal:
+			m.fail(err)
+		case <-done:
 			return
-		case <-sigHUP:
-			m.Reload()
 		}
 	}
+}
+
+// stopDrain stops forwarding the errors sent to the fatal channel.
+func (m *Manager) stopDrain() {
+	if m.drainCh != nil {
+		close(m.drainCh)
+		m.drainCh = nil
+	}
+}
 
+// Restarts returns the number of restarts of a supervised service since
+// the manager was started.
+func (m *Manager) Restarts(name string) (int, bool) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	st, ok := m.stats[name]
+	if !ok || st.sv == nil {
+		return 0, false
+	}
+	_, restarts, _ := st.sv.state()
+	return restarts, true
 }
 
-// Ping will ping all registered services.
+// Ping will ping all registered services. Supervised services that gave up
+// are reported as errors.
 func (m *Manager) Ping() error {
 	if !m.started {
 		return errors.New("serverd: manager not started")
@@ -179,13 +424,15 @@ func (m *Manager) Ping() error {
 	if err != nil {
 		return err
 	}
-	if m.reloadErr != nil {
-		return m.reloadErr
-	}
-	return nil
+	return m.reloadErr()
 }
 
-// Reload will reload all registered services.
+// Reload will reload all registered services in the same order they were
+// registered. Services with PrepareReload function are reloaded in two
+// phases: first all of them prepare the new state, if there were no errors
+// the other services are reloaded and then, if there were no errors,
+// prepared services commit it, otherwise they abort. Services without
+// PrepareReload after an error are not reloaded.
 func (m *Manager) Reload() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -193,27 +440,108 @@ func (m *Manager) Reload() error {
 		return nil
 	}
 	m.logger.Infof("reloading %s services", m.name)
+	m.notify(reloadingMsg("reloading")...)
+	errs := make(map[string]error)
+	succeeded := make([]string, 0, len(m.services))
+	prepared := make([]Service, 0, len(m.services))
+	// prepare two-phase services before applying any change
 	for _, s := range m.services {
+		if s.PrepareReload == nil {
+			continue
+		}
+		m.logger.Debugf("preparing reload of service %s", s.Name)
+		start := time.Now()
+		err := s.prepareReload(m.opts.reloadTimeout)
+		if err != nil {
+			m.logger.Warnf("reloading %s: %v", s.Name, err)
+			errs[s.Name] = err
+		} else {
+			prepared = append(prepared, s)
+			succeeded = append(succeeded, s.Name)
+		}
+		m.recordReload(s.Name, start, err)
+	}
+	for _, s := range m.services {
+		if s.PrepareReload != nil || len(errs) > 0 {
+			continue
+		}
 		m.logger.Debugf("reloading service %s", s.Name)
-		err := s.reload()
+		start := time.Now()
+		err := s.reload(m.opts.reloadTimeout)
 		if err != nil {
 			m.logger.Warnf("reloading %s: %v", s.Name, err)
-			m.reloadErr = fmt.Errorf("serverd: reloading %s: %v", s.Name, err)
-			return m.reloadErr
+			errs[s.Name] = err
+		} else {
+			succeeded = append(succeeded, s.Name)
+		}
+		m.recordReload(s.Name, start, err)
+	}
+	if len(errs) == 0 {
+		for _, s := range prepared {
+			m.logger.Debugf("committing reload of service %s", s.Name)
+			s.commitReload()
+		}
+	} else {
+		for i := len(prepared) - 1; i >= 0; i-- {
+			m.logger.Debugf("aborting reload of service %s", prepared[i].Name)
+			prepared[i].abortReload()
 		}
 	}
-	m.reloadErr = nil
+	m.setReloadErrs(succeeded, errs)
+	err := m.reloadErr()
+	if err != nil {
+		m.notify("READY=1", statusMsg(err.Error()))
+		return err
+	}
+	m.notify("READY=1", statusMsg("running"))
 	return nil
 }
 
+// setReloadErrs clears the errors of the services reloaded or prepared and
+// sets the new errors. Errors of services not reloaded are kept.
+func (m *Manager) setReloadErrs(succeeded []string, errs map[string]error) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	if m.reloadErrs == nil {
+		m.reloadErrs = make(map[string]error)
+	}
+	for _, name := range succeeded {
+		delete(m.reloadErrs, name)
+	}
+	for name, err := range errs {
+		m.reloadErrs[name] = err
+	}
+}
+
+// reloadErr returns the errors of the last reload of the services.
+func (m *Manager) reloadErr() error {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	if len(m.reloadErrs) == 0 {
+		return nil
+	}
+	errs := make([]string, 0, len(m.reloadErrs))
+	for _, s := range m.services {
+		if err, ok := m.reloadErrs[s.Name]; ok {
+			errs = append(errs, fmt.Sprintf("reloading %s: %v", s.Name, err))
+		}
+	}
+	return fmt.Errorf("serverd: %s", strings.Join(errs, ";"))
+}
+
 func (m *Manager) ping() error {
 	errs := make([]string, 0, len(m.services))
 	for _, s := range m.services {
 		m.logger.Debugf("ping service %s", s.Name)
-		err := s.ping()
+		start := time.Now()
+		err := s.ping(m.opts.pingTimeout)
+		m.recordPing(s.Name, start, err)
 		if err != nil {
 			errs = append(errs, fmt.Sprintf("%s: %s", s.Name, err.Error()))
 		}
+		if err := m.supervisorErr(s.Name); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: run: %s", s.Name, err.Error()))
+		}
 	}
 	if len(errs) > 0 {
 		return errors.New(strings.Join(errs, ";"))
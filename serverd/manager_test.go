@@ -0,0 +1,536 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luids-io/core/serverd"
+)
+
+// recorder stores the events of the services in order.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *recorder) index(event string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, e := range r.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *recorder) service(name string, deps ...string) serverd.Service {
+	return serverd.Service{
+		Name:      name,
+		DependsOn: deps,
+		Start: func() error {
+			r.add("start " + name)
+			return nil
+		},
+		Shutdown: func() { r.add("shutdown " + name) },
+	}
+}
+
+func TestRegisterCycles(t *testing.T) {
+	m := serverd.New("test")
+	if err := m.Register(serverd.Service{Name: "a", DependsOn: []string{"c"}}); err != nil {
+		t.Fatalf("Register(a) unexpected err: %v", err)
+	}
+	if err := m.Register(serverd.Service{Name: "b", DependsOn: []string{"a"}}); err != nil {
+		t.Fatalf("Register(b) unexpected err: %v", err)
+	}
+	err := m.Register(serverd.Service{Name: "c", DependsOn: []string{"b"}})
+	if err == nil || !strings.Contains(err.Error(), "a -> c -> b -> a") {
+		t.Errorf("Register(c) unexpected err: %v", err)
+	}
+	if err := m.Register(serverd.Service{Name: "d", DependsOn: []string{"d"}}); err == nil {
+		t.Error("Register(d) expected err")
+	}
+	// c wasn't registered
+	if err := m.Start(); err == nil {
+		t.Error("Start expected err with unknown dependency")
+	}
+	if err := m.Register(serverd.Service{Name: "c"}); err != nil {
+		t.Fatalf("Register(c) unexpected err: %v", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Shutdown()
+}
+
+func TestStartParallel(t *testing.T) {
+	// a and b start only if both are starting at the same time
+	var wg sync.WaitGroup
+	wg.Add(2)
+	barrier := func() error {
+		wg.Done()
+		fin := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(fin)
+		}()
+		select {
+		case <-fin:
+			return nil
+		case <-time.After(time.Second):
+			return errors.New("not started in parallel")
+		}
+	}
+	r := &recorder{}
+	m := serverd.New("test", serverd.ParallelStart())
+	for _, s := range []serverd.Service{
+		r.service("c", "a", "b"),
+		{Name: "a", Start: barrier, Shutdown: func() { r.add("shutdown a") }},
+		{Name: "b", Start: barrier, Shutdown: func() { r.add("shutdown b") }},
+		r.service("d"),
+	} {
+		if err := m.Register(s); err != nil {
+			t.Fatalf("Register(%s) unexpected err: %v", s.Name, err)
+		}
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Shutdown()
+	for _, dep := range []string{"a", "b"} {
+		if r.index("shutdown c") > r.index("shutdown "+dep) {
+			t.Errorf("service c was shut down after %s: %v", dep, r.events)
+		}
+	}
+	if r.index("shutdown d") < 0 {
+		t.Errorf("service d wasn't shut down: %v", r.events)
+	}
+}
+
+func TestStartOrder(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test")
+	m.Register(r.service("c", "b"))
+	m.Register(r.service("b", "a"))
+	m.Register(r.service("a"))
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Shutdown()
+	want := "start a,start b,start c,shutdown c,shutdown b,shutdown a"
+	if got := strings.Join(r.events, ","); got != want {
+		t.Errorf("events want=%v got=%v", want, got)
+	}
+}
+
+func TestStartLegacyOrder(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test")
+	m.Register(r.service("db"))
+	m.Register(r.service("cache"))
+	m.Register(r.service("grpc"))
+	m.Register(r.service("metrics", "db"))
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Shutdown()
+	for _, events := range [][2]string{
+		{"start db", "start cache"},
+		{"start cache", "start grpc"},
+		{"start db", "start metrics"},
+		{"shutdown grpc", "shutdown cache"},
+		{"shutdown cache", "shutdown db"},
+		{"shutdown metrics", "shutdown db"},
+	} {
+		if r.index(events[0]) > r.index(events[1]) || r.index(events[0]) < 0 {
+			t.Errorf("%s wasn't before %s: %v", events[0], events[1], r.events)
+		}
+	}
+}
+
+func TestStartError(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test")
+	a := r.service("a")
+	a.Start = func() error { return errors.New("failed") }
+	m.Register(a)
+	m.Register(r.service("b", "a"))
+	err := m.Start()
+	if err == nil || err.Error() != "serverd: starting a: failed" {
+		t.Errorf("Start unexpected err: %v", err)
+	}
+	if r.index("start b") >= 0 {
+		t.Errorf("service b was started: %v", r.events)
+	}
+}
+
+func TestStartRollback(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test", serverd.ShutdownTimeout(10*time.Millisecond))
+	m.Register(r.service("a"))
+	b := r.service("b", "a")
+	block := make(chan struct{})
+	defer close(block)
+	b.Shutdown = func() { <-block }
+	b.Stop = func() { r.add("stop b") }
+	m.Register(b)
+	c := r.service("c", "b")
+	c.Start = func() error { return errors.New("failed") }
+	m.Register(c)
+	m.Register(r.service("d", "c"))
+	err := m.Start()
+	want := "serverd: starting c: failed;shutting down b: shutdown timeout after 10ms, stopped"
+	if err == nil || err.Error() != want {
+		t.Errorf("Start want err=%v got=%v", want, err)
+	}
+	want = "start a,start b,stop b,shutdown a"
+	if got := strings.Join(r.events, ","); got != want {
+		t.Errorf("events want=%v got=%v", want, got)
+	}
+	// manager is not started
+	if err := m.Ping(); err == nil {
+		t.Error("Ping expected err")
+	}
+}
+
+func TestContextTimeouts(t *testing.T) {
+	r := &recorder{}
+	wait := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	m := serverd.New("test", serverd.PingTimeout(10*time.Millisecond),
+		serverd.ReloadTimeout(10*time.Millisecond))
+	m.Register(serverd.Service{
+		Name: "a",
+		StartContext: func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				return errors.New("start without deadline")
+			}
+			r.add("start a")
+			return nil
+		},
+		StartTimeout:    time.Second,
+		ShutdownContext: wait,
+		ShutdownTimeout: 10 * time.Millisecond,
+		Stop:            func() { r.add("stop a") },
+		PingContext:     wait,
+		ReloadContext:   func(ctx context.Context) error { return nil },
+	})
+	m.Register(serverd.Service{
+		Name:            "b",
+		StartContext:    func(ctx context.Context) error { r.add("start b"); return nil },
+		ShutdownContext: func(ctx context.Context) error { r.add("shutdown b"); return nil },
+		PingContext:     func(ctx context.Context) error { return nil },
+		ReloadContext:   wait,
+		ReloadTimeout:   20 * time.Millisecond,
+	})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	if err := m.Ping(); err == nil || err.Error() != "a: ping timeout after 10ms" {
+		t.Errorf("Ping unexpected err: %v", err)
+	}
+	if err := m.Reload(); err == nil || err.Error() != "serverd: reloading b: reload timeout after 20ms" {
+		t.Errorf("Reload unexpected err: %v", err)
+	}
+	m.Shutdown()
+	if r.index("stop a") < 0 || r.index("shutdown b") < 0 {
+		t.Errorf("unexpected events: %v", r.events)
+	}
+}
+
+func TestShutdownContextIgnored(t *testing.T) {
+	r := &recorder{}
+	block := make(chan struct{})
+	defer close(block)
+	m := serverd.New("test", serverd.DisableSignals())
+	m.Register(serverd.Service{
+		Name: "a",
+		ShutdownContext: func(ctx context.Context) error {
+			<-block // ignores context
+			return nil
+		},
+		ShutdownTimeout: 10 * time.Millisecond,
+		Stop:            func() { r.add("stop a") },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.RunContext(ctx) }()
+	select {
+	case err := <-errCh:
+		want := "serverd: shutting down a: shutdown timeout after 10ms, stopped"
+		if err == nil || err.Error() != want {
+			t.Errorf("RunContext want err=%v got=%v", want, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't time out")
+	}
+	if r.index("stop a") < 0 {
+		t.Error("service a wasn't stopped")
+	}
+}
+
+func TestReloadContextIgnored(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	m := serverd.New("test", serverd.DisableSignals(),
+		serverd.ReloadTimeout(10*time.Millisecond))
+	m.Register(serverd.Service{
+		Name: "a",
+		ReloadContext: func(ctx context.Context) error {
+			<-block // ignores context
+			return nil
+		},
+	})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		m.Reload()
+		m.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reload blocked shutdown")
+	}
+}
+
+func TestStartTimeoutRollback(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test", serverd.StartTimeout(10*time.Millisecond))
+	m.Register(serverd.Service{
+		Name: "a",
+		StartContext: func(ctx context.Context) error {
+			<-ctx.Done()
+			return errors.New("listener not ready")
+		},
+		Shutdown: func() { r.add("shutdown a") },
+	})
+	err := m.Start()
+	want := "serverd: starting a: start timeout after 10ms: listener not ready"
+	if err == nil || err.Error() != want {
+		t.Errorf("Start want err=%v got=%v", want, err)
+	}
+	if r.index("shutdown a") < 0 {
+		t.Errorf("service a wasn't shut down: %v", r.events)
+	}
+}
+
+func TestSupervisedRestarts(t *testing.T) {
+	failures := 2
+	started := make(chan struct{})
+	m := serverd.New("test")
+	m.Register(serverd.Service{
+		Name: "loop",
+		Run: func(ctx context.Context) error {
+			if failures > 0 {
+				failures--
+				return errors.New("failed")
+			}
+			close(started)
+			<-ctx.Done()
+			return nil
+		},
+		Restart: serverd.RestartPolicy{Backoff: time.Millisecond},
+	})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("service wasn't restarted")
+	}
+	if restarts, ok := m.Restarts("loop"); !ok || restarts != 2 {
+		t.Errorf("Restarts want=2 got=%v", restarts)
+	}
+	m.Shutdown()
+}
+
+func TestSupervisedGiveUp(t *testing.T) {
+	runs := 0
+	m := serverd.New("test")
+	m.Register(serverd.Service{
+		Name: "loop",
+		Run: func(ctx context.Context) error {
+			runs++
+			return errors.New("failed")
+		},
+		Restart: serverd.RestartPolicy{MaxRestarts: 3, Backoff: time.Millisecond, StopManager: true},
+	})
+	err := m.Run()
+	if err == nil || err.Error() != "serverd: service loop failed: failed" {
+		t.Errorf("Run unexpected err: %v", err)
+	}
+	if runs != 4 {
+		t.Errorf("runs want=4 got=%v", runs)
+	}
+	if restarts, _ := m.Restarts("loop"); restarts != 3 {
+		t.Errorf("Restarts want=3 got=%v", restarts)
+	}
+}
+
+func TestReloadTwoPhase(t *testing.T) {
+	r := &recorder{}
+	var failB, failC, failD bool
+	twoPhase := func(name string, fail *bool) serverd.Service {
+		return serverd.Service{
+			Name: name,
+			PrepareReload: func(ctx context.Context) error {
+				r.add("prepare " + name)
+				if *fail {
+					return errors.New("bad config")
+				}
+				return nil
+			},
+			CommitReload: func() { r.add("commit " + name) },
+			AbortReload:  func() { r.add("abort " + name) },
+		}
+	}
+	m := serverd.New("test")
+	m.Register(twoPhase("a", new(bool)))
+	m.Register(twoPhase("b", &failB))
+	m.Register(serverd.Service{Name: "c", Reload: func() error {
+		r.add("reload c")
+		if failC {
+			return errors.New("bad config")
+		}
+		return nil
+	}})
+	m.Register(twoPhase("d", &failD))
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	defer m.Shutdown()
+
+	var tests = []struct {
+		failB, failC, failD bool
+		wantErr             string
+		wantEvents          string
+	}{
+		{false, false, false, "",
+			"prepare a,prepare b,prepare d,reload c,commit a,commit b,commit d"},
+		{true, false, false, "serverd: reloading b: bad config",
+			"prepare a,prepare b,prepare d,abort d,abort a"},
+		// c is not reloaded if a prepare fails
+		{false, false, true, "serverd: reloading d: bad config",
+			"prepare a,prepare b,prepare d,abort b,abort a"},
+		{false, true, false, "serverd: reloading c: bad config",
+			"prepare a,prepare b,prepare d,reload c,abort d,abort b,abort a"},
+		{true, false, false, "serverd: reloading b: bad config;reloading c: bad config",
+			"prepare a,prepare b,prepare d,abort d,abort a"},
+		{false, false, false, "",
+			"prepare a,prepare b,prepare d,reload c,commit a,commit b,commit d"},
+	}
+	for i, test := range tests {
+		failB, failC, failD = test.failB, test.failC, test.failD
+		r.events = nil
+		err := m.Reload()
+		if (test.wantErr == "" && err != nil) || (test.wantErr != "" && (err == nil || err.Error() != test.wantErr)) {
+			t.Errorf("%v: Reload want err=%v got=%v", i, test.wantErr, err)
+		}
+		if got := strings.Join(r.events, ","); got != test.wantEvents {
+			t.Errorf("%v: events want=%v got=%v", i, test.wantEvents, got)
+		}
+		err = m.Ping()
+		if (test.wantErr == "" && err != nil) || (test.wantErr != "" && (err == nil || err.Error() != test.wantErr)) {
+			t.Errorf("%v: Ping want err=%v got=%v", i, test.wantErr, err)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test", serverd.DisableSignals())
+	m.Register(r.service("a"))
+	m.Register(serverd.Service{
+		Name:            "b",
+		ShutdownContext: func(ctx context.Context) error { return errors.New("failed") },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.RunContext(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if err == nil || err.Error() != "serverd: shutting down b: failed" {
+			t.Errorf("RunContext unexpected err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunContext didn't return")
+	}
+	if r.index("shutdown a") < 0 {
+		t.Error("service a wasn't shut down")
+	}
+}
+
+func TestRunContextFatal(t *testing.T) {
+	r := &recorder{}
+	m := serverd.New("test", serverd.DisableSignals())
+	m.Register(r.service("a"))
+	m.Register(serverd.Service{
+		Name: "b",
+		Start: func() error {
+			go func() { m.Fatal() <- errors.New("serverd: b lost connection") }()
+			return nil
+		},
+		ShutdownContext: func(ctx context.Context) error { return errors.New("failed") },
+	})
+	err := m.RunContext(context.Background())
+	if err == nil || err.Error() != "serverd: b lost connection;shutting down b: failed" {
+		t.Errorf("RunContext unexpected err: %v", err)
+	}
+	if r.index("shutdown a") < 0 {
+		t.Error("service a wasn't shut down")
+	}
+}
+
+func TestRunContextFatalTwice(t *testing.T) {
+	m := serverd.New("test", serverd.DisableSignals())
+	sent := make(chan struct{})
+	m.Register(serverd.Service{
+		Name: "a",
+		Start: func() error {
+			go func() {
+				m.Fatal() <- errors.New("first")
+				m.Fatal() <- errors.New("second")
+				m.Fatal() <- errors.New("third")
+				close(sent)
+			}()
+			return nil
+		},
+		ShutdownContext: func(ctx context.Context) error {
+			// the manager must not block senders while shutting down
+			select {
+			case <-sent:
+				return nil
+			case <-ctx.Done():
+				return errors.New("fatal send blocked")
+			}
+		},
+		ShutdownTimeout: time.Second,
+	})
+	err := m.RunContext(context.Background())
+	if err == nil || err.Error() != "first" {
+		t.Errorf("RunContext unexpected err: %v", err)
+	}
+}
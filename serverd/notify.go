@@ -0,0 +1,110 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NotifySocket option sets the socket used to send notifications to the
+// service manager with the systemd notify protocol. By default the value of
+// the NOTIFY_SOCKET environment variable is used, an empty path disables
+// notifications.
+func NotifySocket(path string) Option {
+	return func(o *options) {
+		o.notifySocket = path
+	}
+}
+
+// WatchdogInterval option sets the interval of watchdog keepalives. By
+// default it's half of the value of the WATCHDOG_USEC environment variable,
+// zero disables keepalives.
+func WatchdogInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.watchdogInterval = d
+	}
+}
+
+// notifier sends notifications using the systemd notify protocol.
+type notifier struct {
+	socket string
+}
+
+// notify sends the state to the socket, if there is no socket it does
+// nothing.
+func (n notifier) notify(state ...string) error {
+	if n.socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	return err
+}
+
+// statusMsg returns a STATUS message, status must be in a single line.
+func statusMsg(status string) string {
+	return "STATUS=" + strings.Replace(status, "\n", " ", -1)
+}
+
+// reloadingMsg returns the messages sent when reloading starts. The service
+// manager requires the timestamp of CLOCK_MONOTONIC with RELOADING=1.
+func reloadingMsg(status string) []string {
+	msgs := []string{"RELOADING=1"}
+	if usec, ok := monotonicUsec(); ok {
+		msgs = append(msgs, "MONOTONIC_USEC="+strconv.FormatInt(usec, 10))
+	}
+	return append(msgs, statusMsg(status))
+}
+
+// envWatchdogInterval returns the interval for keepalives from the
+// environment variables set by systemd.
+func envWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// SetStatus sends a status message to the service manager.
+func (m *Manager) SetStatus(status string) error {
+	return m.notifier.notify(statusMsg(status))
+}
+
+func (m *Manager) notify(state ...string) {
+	err := m.notifier.notify(state...)
+	if err != nil {
+		m.logger.Warnf("notifying %s: %v", strings.Join(state, ","), err)
+	}
+}
+
+// watchdog sends keepalives while ping is ok until done is closed.
+func (m *Manager) watchdog(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := m.ping()
+			if err != nil {
+				m.logger.Warnf("watchdog: ping %s services: %v", m.name, err)
+				m.notify(statusMsg("ping failed: " + err.Error()))
+				continue
+			}
+			m.notify("WATCHDOG=1")
+		case <-done:
+			return
+		}
+	}
+}
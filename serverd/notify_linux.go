@@ -0,0 +1,23 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+//go:build linux
+// +build linux
+
+package serverd
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const clockMonotonic = 1 // CLOCK_MONOTONIC
+
+// monotonicUsec returns the value of CLOCK_MONOTONIC in microseconds.
+func monotonicUsec() (int64, bool) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, false
+	}
+	return ts.Nano() / 1000, true
+}
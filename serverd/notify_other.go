@@ -0,0 +1,12 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+//go:build !linux
+// +build !linux
+
+package serverd
+
+// monotonicUsec is only available in linux, where the notify protocol is
+// used.
+func monotonicUsec() (int64, bool) {
+	return 0, false
+}
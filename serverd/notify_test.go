@@ -0,0 +1,142 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luids-io/core/serverd"
+)
+
+// listenNotify returns a unixgram socket that receives notifications.
+func listenNotify(t *testing.T) (string, *net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "serverd")
+	if err != nil {
+		t.Fatalf("creating temp dir: %v", err)
+	}
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("listening notify socket: %v", err)
+	}
+	return path, conn, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("reading notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	path, conn, cleanup := listenNotify(t)
+	defer cleanup()
+
+	reloadErr := errors.New("bad config")
+	m := serverd.New("test", serverd.NotifySocket(path))
+	m.Register(serverd.Service{Name: "a", Reload: func() error { return reloadErr }})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Reload()
+	reloadErr = nil
+	m.Reload()
+	m.SetStatus("custom\nstatus")
+	m.Shutdown()
+	want := []string{
+		"STATUS=starting",
+		"READY=1\nSTATUS=running",
+		"RELOADING=1\nSTATUS=reloading",
+		"READY=1\nSTATUS=serverd: reloading a: bad config",
+		"RELOADING=1\nSTATUS=reloading",
+		"READY=1\nSTATUS=running",
+		"STATUS=custom status",
+		"STOPPING=1\nSTATUS=stopping",
+	}
+	for _, w := range want {
+		got := readNotify(t, conn)
+		if strings.HasPrefix(got, "RELOADING=1\n") {
+			got = stripMonotonic(t, got)
+		}
+		if got != w {
+			t.Errorf("notification want=%q got=%q", w, got)
+		}
+	}
+}
+
+// stripMonotonic checks and removes the MONOTONIC_USEC field.
+func stripMonotonic(t *testing.T, msg string) string {
+	lines := strings.Split(msg, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "MONOTONIC_USEC=") {
+			usec, err := strconv.ParseInt(strings.TrimPrefix(line, "MONOTONIC_USEC="), 10, 64)
+			if err != nil || usec <= 0 {
+				t.Errorf("invalid monotonic timestamp: %q", line)
+			}
+			return strings.Join(append(lines[:i], lines[i+1:]...), "\n")
+		}
+	}
+	if runtime.GOOS == "linux" {
+		t.Errorf("monotonic timestamp not found: %q", msg)
+	}
+	return msg
+}
+
+func TestWatchdog(t *testing.T) {
+	path, conn, cleanup := listenNotify(t)
+	defer cleanup()
+
+	var ready int32
+	pinged := make(chan struct{}, 10)
+	m := serverd.New("test", serverd.NotifySocket(path), serverd.WatchdogInterval(10*time.Millisecond))
+	m.Register(serverd.Service{Name: "a", Ping: func() error {
+		defer func() { pinged <- struct{}{} }()
+		if atomic.LoadInt32(&ready) == 0 {
+			return errors.New("not ready")
+		}
+		return nil
+	}})
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	readNotify(t, conn) // starting
+	readNotify(t, conn) // ready
+	if got := readNotify(t, conn); !strings.HasPrefix(got, "STATUS=ping failed: a: not ready") {
+		t.Errorf("unexpected notification: %q", got)
+	}
+	<-pinged
+	atomic.StoreInt32(&ready, 1)
+	for i := 0; i < 5; i++ {
+		if got := readNotify(t, conn); got == "WATCHDOG=1" {
+			m.Shutdown()
+			return
+		}
+	}
+	t.Error("keepalive not received")
+	m.Shutdown()
+}
+
+func TestNotifyDisabled(t *testing.T) {
+	m := serverd.New("test", serverd.NotifySocket(""))
+	if err := m.SetStatus("running"); err != nil {
+		t.Errorf("SetStatus unexpected err: %v", err)
+	}
+}
@@ -3,17 +3,45 @@
 package serverd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 )
 
 // Service defines data struct for services.
 type Service struct {
-	Name     string
-	Start    StartupFn
-	Shutdown ShutdownFn
-	Stop     StopFn
-	Reload   ReloadFn
-	Ping     PingFn
+	Name string
+	// DependsOn stores the names of the services that must be started
+	// before this service and stopped after it
+	DependsOn []string
+	Start     StartupFn
+	Shutdown  ShutdownFn
+	Stop      StopFn
+	Reload    ReloadFn
+	Ping      PingFn
+	// Context variants, if defined they are used instead of the functions
+	// above. The context is cancelled when the timeout expires
+	StartContext    StartupContextFn
+	ShutdownContext ShutdownContextFn
+	ReloadContext   ReloadContextFn
+	PingContext     PingContextFn
+	// Two-phase reload, if PrepareReload is defined it is used instead of
+	// reload functions. Manager commits when all services are prepared,
+	// otherwise it aborts
+	PrepareReload PrepareReloadFn
+	CommitReload  CommitReloadFn
+	AbortReload   AbortReloadFn
+	// Run is the function of long-running services, it's executed after
+	// start and supervised by the manager using the restart policy. When
+	// the service is shut down, its context is cancelled
+	Run     RunFn
+	Restart RestartPolicy
+	// Timeouts of the service, if zero the timeouts of the manager are used
+	StartTimeout    time.Duration
+	ShutdownTimeout time.Duration
+	ReloadTimeout   time.Duration
+	PingTimeout     time.Duration
 }
 
 // StartupFn sets definition for startup functions.
@@ -31,7 +59,65 @@ type ReloadFn func() error
 // PingFn sets definition for ping functions.
 type PingFn func() error
 
-func (s Service) start() error {
+// StartupContextFn sets definition for startup functions with context.
+type StartupContextFn func(ctx context.Context) error
+
+// ShutdownContextFn sets definition for gracely shutdown functions with
+// context. If context expires, the stop function will be executed.
+type ShutdownContextFn func(ctx context.Context) error
+
+// ReloadContextFn sets definition for reload functions with context.
+type ReloadContextFn func(ctx context.Context) error
+
+// PingContextFn sets definition for ping functions with context.
+type PingContextFn func(ctx context.Context) error
+
+// PrepareReloadFn sets definition for functions that validate and prepare
+// the new state of a service, without applying it.
+type PrepareReloadFn func(ctx context.Context) error
+
+// CommitReloadFn sets definition for functions that apply the prepared
+// state.
+type CommitReloadFn func()
+
+// AbortReloadFn sets definition for functions that discard the prepared
+// state.
+type AbortReloadFn func()
+
+// TimeoutError is returned when a service overruns the timeout of an
+// operation.
+type TimeoutError struct {
+	// Op is the operation: start, shutdown, reload or ping
+	Op      string
+	Timeout time.Duration
+	// Stopped is true if the stop function was executed
+	Stopped bool
+	// Err is the error returned by the operation, if any
+	Err error
+}
+
+// Error implements error interface.
+func (e *TimeoutError) Error() string {
+	msg := fmt.Sprintf("%s timeout after %v", e.Op, e.Timeout)
+	if e.Stopped {
+		msg = msg + ", stopped"
+	}
+	if e.Err != nil && e.Err != context.DeadlineExceeded {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+// isTimeout returns true if err is a TimeoutError.
+func isTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
+}
+
+func (s Service) start(timeout time.Duration) error {
+	if s.StartContext != nil {
+		return callContext("start", s.timeout(s.StartTimeout, timeout), s.StartContext)
+	}
 	var err error
 	if s.Start != nil {
 		err = s.Start()
@@ -39,7 +125,32 @@ func (s Service) start() error {
 	return err
 }
 
-func (s Service) shutdown(timeout time.Duration) {
+func (s Service) shutdown(timeout time.Duration, force <-chan struct{}) error {
+	timeout = s.timeout(s.ShutdownTimeout, timeout)
+	if s.ShutdownContext != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		fin := make(chan error, 1)
+		go func() { fin <- s.ShutdownContext(ctx) }()
+		var err error
+		select {
+		case err = <-fin:
+		case <-ctx.Done():
+			// don't wait for functions that ignore the context
+		case <-force:
+			cancel()
+			return s.forced()
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			terr := &TimeoutError{Op: "shutdown", Timeout: timeout, Err: err}
+			if s.Stop != nil {
+				s.Stop()
+				terr.Stopped = true
+			}
+			return terr
+		}
+		return err
+	}
 	if s.Shutdown != nil {
 		fin := make(chan struct{})
 		go func() {
@@ -48,18 +159,35 @@ func (s Service) shutdown(timeout time.Duration) {
 		}()
 		select {
 		case <-time.After(timeout):
+			terr := &TimeoutError{Op: "shutdown", Timeout: timeout}
 			if s.Stop != nil {
 				s.Stop()
+				terr.Stopped = true
 			}
+			return terr
+		case <-force:
+			return s.forced()
 		case <-fin:
 		}
 	} else if s.Stop != nil {
 		s.Stop()
 	}
-	return
+	return nil
+}
+
+// forced executes the stop function when the shutdown is forced.
+func (s Service) forced() error {
+	if s.Stop != nil {
+		s.Stop()
+		return errors.New("shutdown forced, stopped")
+	}
+	return errors.New("shutdown forced")
 }
 
-func (s Service) ping() error {
+func (s Service) ping(timeout time.Duration) error {
+	if s.PingContext != nil {
+		return callContext("ping", s.timeout(s.PingTimeout, timeout), s.PingContext)
+	}
 	var err error
 	if s.Ping != nil {
 		err = s.Ping()
@@ -67,10 +195,69 @@ func (s Service) ping() error {
 	return err
 }
 
-func (s Service) reload() error {
+func (s Service) prepareReload(timeout time.Duration) error {
+	return callContext("reload", s.timeout(s.ReloadTimeout, timeout), s.PrepareReload)
+}
+
+func (s Service) commitReload() {
+	if s.CommitReload != nil {
+		s.CommitReload()
+	}
+}
+
+func (s Service) abortReload() {
+	if s.AbortReload != nil {
+		s.AbortReload()
+	}
+}
+
+func (s Service) reload(timeout time.Duration) error {
+	if s.ReloadContext != nil {
+		return callContext("reload", s.timeout(s.ReloadTimeout, timeout), s.ReloadContext)
+	}
 	var err error
 	if s.Reload != nil {
 		err = s.Reload()
 	}
 	return err
 }
+
+// timeout returns the timeout of the service or the default timeout.
+func (s Service) timeout(timeout, def time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return def
+}
+
+// cancelGrace is the time that callContext waits for functions to return
+// after their context expires.
+const cancelGrace = 100 * time.Millisecond
+
+// callContext calls fn with a context that expires after timeout, if timeout
+// is zero it never expires. It returns a TimeoutError if fn overruns, without
+// waiting for functions that ignore the context.
+func callContext(op string, timeout time.Duration, fn func(context.Context) error) error {
+	if timeout <= 0 {
+		return fn(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	fin := make(chan error, 1)
+	go func() { fin <- fn(ctx) }()
+	var err error
+	select {
+	case err = <-fin:
+	case <-ctx.Done():
+		// give some time to return the error, but don't wait for functions
+		// that ignore the context
+		select {
+		case err = <-fin:
+		case <-time.After(cancelGrace):
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return &TimeoutError{Op: op, Timeout: timeout, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+// SignalFn sets definition for functions executed when a signal arrives.
+type SignalFn func()
+
+// OnSignal option executes fn when the signal arrives, replacing the
+// default action of the signal. If fn is nil, the signal is ignored.
+// By default SIGHUP reloads services, SIGUSR1 dumps status and goroutines
+// to the log and SIGUSR2 reopens the log files if logger implements
+// yalogi.Reopener. Shutdown signals are not affected by this option.
+func OnSignal(sig os.Signal, fn SignalFn) Option {
+	return func(o *options) {
+		if o.signalFns == nil {
+			o.signalFns = make(map[os.Signal]SignalFn)
+		}
+		o.signalFns[sig] = fn
+	}
+}
+
+// ShutdownSignals option sets the signals that shut down the manager. By
+// default SIGINT and SIGTERM.
+func ShutdownSignals(sigs ...os.Signal) Option {
+	return func(o *options) {
+		o.shutdownSignals = sigs
+	}
+}
+
+// DisableSignals option disables the handling of signals, useful when the
+// manager is embedded.
+func DisableSignals() Option {
+	return func(o *options) {
+		o.disableSignals = true
+	}
+}
+
+// signalFns returns the functions by signal, excluding shutdown signals.
+func (m *Manager) signalFns() map[os.Signal]SignalFn {
+	fns := map[os.Signal]SignalFn{
+		syscall.SIGHUP: func() { m.Reload() },
+	}
+	addPlatformSignals(m, fns)
+	for sig, fn := range m.opts.signalFns {
+		if fn == nil {
+			delete(fns, sig)
+			continue
+		}
+		fns[sig] = fn
+	}
+	// shutdown signals have precedence
+	for _, sig := range m.opts.shutdownSignals {
+		delete(fns, sig)
+	}
+	return fns
+}
+
+func (m *Manager) signalHndl(close chan bool, done chan struct{}) {
+	fns := m.signalFns()
+	sigs := make([]os.Signal, 0, len(fns))
+	for sig := range fns {
+		sigs = append(sigs, sig)
+	}
+	sigShutdown := make(chan os.Signal, 1)
+	sigAction := make(chan os.Signal, 1)
+	signal.Notify(sigShutdown, m.opts.shutdownSignals...)
+	if len(sigs) > 0 {
+		signal.Notify(sigAction, sigs...)
+	}
+	defer signal.Stop(sigShutdown)
+	defer signal.Stop(sigAction)
+	shuttingDown := false
+	for {
+		select {
+		case sig := <-sigShutdown:
+			if shuttingDown {
+				m.logger.Warnf("received %v again, forcing stop of %s services", sig, m.name)
+				m.forceStop()
+				continue
+			}
+			m.logger.Infof("received %v", sig)
+			shuttingDown = true
+			close <- true
+		case sig := <-sigAction:
+			if shuttingDown {
+				continue
+			}
+			m.logger.Debugf("received %v", sig)
+			go fns[sig]()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Dump writes to the log the status of the services and the stack traces
+// of all goroutines.
+func (m *Manager) Dump() {
+	st := m.Status()
+	m.logger.Infof("dump %s: started=%v", st.Name, st.Started)
+	for _, s := range st.Services {
+		m.logger.Infof("dump %s: service=%s state=%s since=%v restarts=%v lasterror='%s'",
+			st.Name, s.Name, s.State, s.Since, s.Restarts, s.LastError)
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	m.logger.Infof("dump %s: goroutines\n%s", st.Name, buf)
+}
+
+// reopenLog reopens the log files if logger implements yalogi.Reopener.
+func (m *Manager) reopenLog() {
+	r, ok := m.logger.(yalogi.Reopener)
+	if !ok {
+		return
+	}
+	err := r.Reopen()
+	if err != nil {
+		m.logger.Errorf("reopening log: %v", err)
+		return
+	}
+	m.logger.Infof("log reopened")
+}
+
+// resetForce creates a new channel for forcing stops.
+func (m *Manager) resetForce() {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	m.force = make(chan struct{})
+}
+
+// forceCh returns the channel closed when the stop is forced.
+func (m *Manager) forceCh() chan struct{} {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	return m.force
+}
+
+// forceStop stops the services that are shutting down without waiting.
+func (m *Manager) forceStop() {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	select {
+	case <-m.force:
+	default:
+		close(m.force)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+//go:build !windows
+// +build !windows
+
+package serverd_test
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/luids-io/core/serverd"
+	"github.com/luids-io/core/yalogi"
+)
+
+// sendUntil sends the signal to the process until cond is true.
+func sendUntil(t *testing.T, sig syscall.Signal, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		syscall.Kill(os.Getpid(), sig)
+		time.Sleep(10 * time.Millisecond)
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("signal %v not handled", sig)
+}
+
+func TestSignals(t *testing.T) {
+	// avoid default actions if signals arrive before the handler is set
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(ignored)
+
+	var usr1, usr2, stopped int32
+	block := make(chan struct{})
+	defer close(block)
+	m := serverd.New("test",
+		serverd.ShutdownTimeout(time.Minute),
+		serverd.OnSignal(syscall.SIGUSR1, func() { atomic.StoreInt32(&usr1, 1) }),
+		serverd.OnSignal(syscall.SIGUSR2, func() { atomic.StoreInt32(&usr2, 1) }),
+		serverd.ShutdownSignals(syscall.SIGUSR2, syscall.SIGTERM),
+	)
+	m.Register(serverd.Service{
+		Name:     "a",
+		Shutdown: func() { <-block },
+		Stop:     func() { atomic.StoreInt32(&stopped, 1) },
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.Run() }()
+
+	sendUntil(t, syscall.SIGUSR1, func() bool { return atomic.LoadInt32(&usr1) == 1 })
+	// graceful shutdown blocks, second signal forces stop. SIGUSR2 is a
+	// shutdown signal, so its action must not be executed
+	sendUntil(t, syscall.SIGUSR2, func() bool {
+		return m.Status().Services[0].State == serverd.StateStopping
+	})
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		if err == nil || err.Error() != "serverd: shutting down a: shutdown forced, stopped" {
+			t.Errorf("Run unexpected err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop wasn't forced")
+	}
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Error("service wasn't stopped")
+	}
+	if atomic.LoadInt32(&usr2) != 0 {
+		t.Error("SIGUSR2 action was executed")
+	}
+}
+
+// reopenLogger is a logger that counts the calls to Reopen.
+type reopenLogger struct {
+	yalogi.Logger
+	reopens int32
+}
+
+func (l *reopenLogger) Reopen() error {
+	atomic.AddInt32(&l.reopens, 1)
+	return nil
+}
+
+func TestSignalReopen(t *testing.T) {
+	ignored := make(chan os.Signal, 1)
+	signal.Notify(ignored, syscall.SIGUSR2)
+	defer signal.Stop(ignored)
+
+	logger := &reopenLogger{Logger: yalogi.LogNull}
+	m := serverd.New("test", serverd.SetLogger(logger))
+	m.Register(serverd.Service{Name: "a"})
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- m.RunContext(ctx) }()
+
+	sendUntil(t, syscall.SIGUSR2, func() bool { return atomic.LoadInt32(&logger.reopens) > 0 })
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("RunContext unexpected err: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+//go:build !windows
+// +build !windows
+
+package serverd
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+func addPlatformSignals(m *Manager, fns map[os.Signal]SignalFn) {
+	fns[syscall.SIGUSR1] = m.Dump
+	if _, ok := m.logger.(yalogi.Reopener); ok {
+		fns[syscall.SIGUSR2] = m.reopenLog
+	}
+}
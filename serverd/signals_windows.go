@@ -0,0 +1,10 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+//go:build windows
+// +build windows
+
+package serverd
+
+import "os"
+
+func addPlatformSignals(m *Manager, fns map[os.Signal]SignalFn) {}
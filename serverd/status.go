@@ -0,0 +1,180 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Service states.
+const (
+	StateStopped  = "stopped"
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopping = "stopping"
+	StateFailed   = "failed"
+)
+
+// Status stores a snapshot of the status of the manager.
+type Status struct {
+	Name     string          `json:"name"`
+	Started  bool            `json:"started"`
+	Services []ServiceStatus `json:"services"`
+}
+
+// ServiceStatus stores a snapshot of the status of a service. Durations are
+// encoded in json as nanoseconds.
+type ServiceStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Since is the time of the last change of state
+	Since time.Time `json:"since"`
+	// Restarts of the run function of supervised services
+	Restarts   int           `json:"restarts"`
+	LastReload time.Time     `json:"lastreload"`
+	ReloadTime time.Duration `json:"reloadtime"`
+	LastPing   time.Time     `json:"lastping"`
+	PingTime   time.Duration `json:"pingtime"`
+	// LastError is the last error returned by the service
+	LastError string `json:"lasterror,omitempty"`
+}
+
+// serviceStats stores the information used to build the status.
+type serviceStats struct {
+	state      string
+	since      time.Time
+	lastReload time.Time
+	reloadTime time.Duration
+	lastPing   time.Time
+	pingTime   time.Duration
+	lastErr    error
+	sv         *supervisor
+}
+
+// Status returns a snapshot of the status of the manager and its services.
+// It doesn't block while services are starting or reloading.
+func (m *Manager) Status() Status {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	status := Status{
+		Name:     m.name,
+		Started:  m.running,
+		Services: make([]ServiceStatus, 0, len(m.order)),
+	}
+	for _, name := range m.order {
+		st := m.stats[name]
+		ss := ServiceStatus{
+			Name:       name,
+			State:      st.state,
+			Since:      st.since,
+			LastReload: st.lastReload,
+			ReloadTime: st.reloadTime,
+			LastPing:   st.lastPing,
+			PingTime:   st.pingTime,
+		}
+		if st.lastErr != nil {
+			ss.LastError = st.lastErr.Error()
+		}
+		if st.sv != nil {
+			_, restarts, _ := st.sv.state()
+			ss.Restarts = restarts
+			if finished, err := st.sv.result(); finished && st.state == StateRunning {
+				ss.State = StateStopped
+				if err != nil {
+					ss.State = StateFailed
+					ss.LastError = err.Error()
+				}
+			}
+		}
+		status.Services = append(status.Services, ss)
+	}
+	return status
+}
+
+// StatusHandler returns an http handler that returns the status in json.
+func (m *Manager) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(m.Status())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+}
+
+// addStats creates the stats of a registered service.
+func (m *Manager) addStats(name string) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	if m.stats == nil {
+		m.stats = make(map[string]*serviceStats)
+	}
+	m.stats[name] = &serviceStats{state: StateStopped, since: time.Now()}
+	m.order = append(m.order, name)
+}
+
+// setState changes the state of a service, if err is not nil it's stored
+// as last error.
+func (m *Manager) setState(name, state string, err error) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	st := m.stats[name]
+	if st.state != state {
+		st.state, st.since = state, time.Now()
+	}
+	if err != nil {
+		st.lastErr = err
+	}
+}
+
+// setSupervisor sets the supervisor of a service.
+func (m *Manager) setSupervisor(name string, sv *supervisor) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	m.stats[name].sv = sv
+}
+
+// setRunning sets the state of the manager.
+func (m *Manager) setRunning(running bool) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	m.running = running
+}
+
+// supervisorErr returns the error of a supervised service that gave up.
+func (m *Manager) supervisorErr(name string) error {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	st := m.stats[name]
+	if st.sv == nil {
+		return nil
+	}
+	_, err := st.sv.result()
+	return err
+}
+
+// recordReload stores the time and the result of the reload of a service.
+func (m *Manager) recordReload(name string, start time.Time, err error) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	st := m.stats[name]
+	st.lastReload, st.reloadTime = start, time.Since(start)
+	if err != nil {
+		st.lastErr = err
+	}
+}
+
+// recordPing stores the time and the result of the ping of a service.
+func (m *Manager) recordPing(name string, start time.Time, err error) {
+	m.statmu.Lock()
+	defer m.statmu.Unlock()
+	st := m.stats[name]
+	st.lastPing, st.pingTime = start, time.Since(start)
+	if err != nil {
+		st.lastErr = err
+	}
+}
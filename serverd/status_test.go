@@ -0,0 +1,88 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luids-io/core/serverd"
+)
+
+func TestStatus(t *testing.T) {
+	m := serverd.New("test")
+	m.Register(serverd.Service{
+		Name:   "a",
+		Reload: func() error { return errors.New("bad config") },
+		Ping: func() error {
+			time.Sleep(time.Millisecond)
+			return nil
+		},
+	})
+	m.Register(serverd.Service{
+		Name:    "b",
+		Run:     func(ctx context.Context) error { return errors.New("failed") },
+		Restart: serverd.RestartPolicy{MaxRestarts: -1},
+	})
+	m.Register(serverd.Service{
+		Name: "c",
+		Run:  func(ctx context.Context) error { return nil },
+	})
+	st := m.Status()
+	if st.Started || len(st.Services) != 3 || st.Services[0].State != serverd.StateStopped {
+		t.Errorf("unexpected status: %+v", st)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("Start unexpected err: %v", err)
+	}
+	m.Reload()
+	m.Ping()
+	// wait until b gives up and c finishes
+	for i := 0; i < 100; i++ {
+		st = m.Status()
+		if st.Services[1].State == serverd.StateFailed && st.Services[2].State == serverd.StateStopped {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	st = m.Status()
+	a, b, c := st.Services[0], st.Services[1], st.Services[2]
+	if !st.Started || a.State != serverd.StateRunning || a.LastError != "bad config" {
+		t.Errorf("unexpected status of a: %+v", a)
+	}
+	if a.LastReload.IsZero() || a.LastPing.IsZero() || a.PingTime < time.Millisecond {
+		t.Errorf("unexpected times of a: %+v", a)
+	}
+	if b.State != serverd.StateFailed || b.LastError != "failed" || b.Restarts != 0 {
+		t.Errorf("unexpected status of b: %+v", b)
+	}
+	if c.State != serverd.StateStopped || c.LastError != "" {
+		t.Errorf("unexpected status of c: %+v", c)
+	}
+	if err := m.Ping(); err == nil || err.Error() != "b: run: failed" {
+		t.Errorf("Ping unexpected err: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	m.StatusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/status", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("unexpected content type: %v", ct)
+	}
+	var got serverd.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding status: %v", err)
+	}
+	if got.Name != "test" || len(got.Services) != 3 || got.Services[0].LastError != "bad config" {
+		t.Errorf("unexpected status: %+v", got)
+	}
+
+	m.Shutdown()
+	st = m.Status()
+	if st.Started || st.Services[0].State != serverd.StateStopped {
+		t.Errorf("unexpected status: %+v", st)
+	}
+}
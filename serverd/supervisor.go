@@ -0,0 +1,165 @@
+// Copyright 2020 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package serverd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+// RunFn sets definition for functions of long-running services. They must
+// return when context is cancelled.
+type RunFn func(ctx context.Context) error
+
+// RestartPolicy defines what the manager does when the run function of a
+// supervised service returns an error.
+type RestartPolicy struct {
+	// MaxRestarts is the number of restarts before giving up, if zero the
+	// service is restarted forever and if negative it's never restarted
+	MaxRestarts int
+	// Backoff is the initial wait before restarting, it's doubled after
+	// each failure up to MaxBackoff. Defaults are 100ms and 30s
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// StopManager stops the manager when the service gives up
+	StopManager bool
+}
+
+func (p RestartPolicy) backoff() (time.Duration, time.Duration) {
+	backoff, max := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if max < backoff {
+		max = backoff
+	}
+	return backoff, max
+}
+
+// supervisor runs and restarts the run function of a service.
+type supervisor struct {
+	svc    Service
+	logger yalogi.Logger
+	fatal  func(error)
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	restarts int
+	running  bool
+	lastErr  error
+	finished bool  // run function returned without being stopped
+	exitErr  error // error of the run function when it gave up
+}
+
+func newSupervisor(svc Service, logger yalogi.Logger, fatal func(error)) *supervisor {
+	return &supervisor{
+		svc:    svc,
+		logger: logger,
+		fatal:  fatal,
+		done:   make(chan struct{}),
+	}
+}
+
+func (sv *supervisor) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sv.cancel = cancel
+	sv.setRunning(true, nil)
+	go sv.run(ctx)
+}
+
+func (sv *supervisor) run(ctx context.Context) {
+	defer close(sv.done)
+	policy := sv.svc.Restart
+	backoff, max := policy.backoff()
+	for {
+		err := sv.svc.Run(ctx)
+		if ctx.Err() != nil {
+			sv.setRunning(false, nil)
+			return
+		}
+		if err == nil {
+			sv.logger.Infof("service %s finished", sv.svc.Name)
+			sv.setRunning(false, nil)
+			sv.finish(nil)
+			return
+		}
+		sv.setRunning(false, err)
+		_, restarts, _ := sv.state()
+		if policy.MaxRestarts < 0 || (policy.MaxRestarts > 0 && restarts >= policy.MaxRestarts) {
+			sv.logger.Errorf("service %s failed, giving up after %v restarts: %v", sv.svc.Name, restarts, err)
+			sv.finish(err)
+			if policy.StopManager {
+				sv.fatal(fmt.Errorf("serverd: service %s failed: %v", sv.svc.Name, err))
+			}
+			return
+		}
+		sv.logger.Warnf("service %s failed, restarting in %v: %v", sv.svc.Name, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = backoff * 2
+		if backoff > max {
+			backoff = max
+		}
+		sv.mu.Lock()
+		sv.restarts++
+		sv.running = true
+		sv.mu.Unlock()
+	}
+}
+
+// stop cancels the run function and waits until it returns or the stop is
+// forced.
+func (sv *supervisor) stop(timeout time.Duration, force <-chan struct{}) error {
+	sv.cancel()
+	select {
+	case <-sv.done:
+		return nil
+	case <-time.After(timeout):
+		return &TimeoutError{Op: "run", Timeout: timeout}
+	case <-force:
+		return errors.New("run forced")
+	}
+}
+
+// result returns true if the run function finished without being stopped
+// and the error returned when it gave up, if any.
+func (sv *supervisor) result() (bool, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.finished, sv.exitErr
+}
+
+func (sv *supervisor) finish(err error) {
+	sv.mu.Lock()
+	sv.finished, sv.exitErr = true, err
+	sv.mu.Unlock()
+}
+
+func (sv *supervisor) setRunning(running bool, err error) {
+	sv.mu.Lock()
+	sv.running = running
+	if err != nil {
+		sv.lastErr = err
+	}
+	sv.mu.Unlock()
+}
+
+// state returns if run function is running, the number of restarts and the
+// last error returned.
+func (sv *supervisor) state() (bool, int, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+	return sv.running, sv.restarts, sv.lastErr
+}
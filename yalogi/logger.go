@@ -34,6 +34,12 @@ type Logger interface {
 	Fatalf(template string, args ...interface{})
 }
 
+// Reopener must be implemented by loggers that can reopen their files, for
+// example after being rotated.
+type Reopener interface {
+	Reopen() error
+}
+
 // LogNull is an instance of a logger object that does nothing.
 var LogNull = &nullLogger{}
 